@@ -0,0 +1,239 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// sAdminHandler is the HTTP handler of the admin endpoint
+type sAdminHandler struct {
+	l *SLogger
+}
+
+// sRuleReq is the body of a writer or function rule change, missing values are not changed
+type sRuleReq struct {
+	Severity   *tSeverity `json:"sev"`
+	DebugLevel *tDebLevel `json:"dlev"`
+}
+
+// merge: return the rule r with the values set in the request
+func (req sRuleReq) merge(r sBaseRule) sBaseRule {
+	if req.Severity != nil {
+		r.Severity = *req.Severity
+	}
+	if req.DebugLevel != nil {
+		r.DebugLevel = *req.DebugLevel
+	}
+	return r
+}
+
+// sMainRuleReq is the body of a main rule change, missing values are not changed
+type sMainRuleReq struct {
+	sRuleReq
+	UseFncRules *bool `json:"usefncrules"`
+}
+
+// AdminHandler returns an HTTP handler to inspect and change the rules
+// while the application is running. Paths are relative to the mount point:
+//   - GET    /rules                                 current rules
+//   - GET    /rules/writers/{writer}                current writer rules
+//   - PUT    /rules/main                            {"sev":5, "dlev":3, "usefncrules":true}
+//   - PUT    /rules/writers/{writer}                {"sev":5, "dlev":3}
+//   - PUT    /rules/writers/{writer}/fncrules/{fnc} {"sev":5, "dlev":3}
+//   - DELETE /rules/writers/{writer}/fncrules/{fnc}
+//   - POST   /flush
+//   - POST   /reopen
+//
+// The PUT requests change only the values in the body, the others are kept.
+// The rule changes reply with the new rules. Mount it with http.StripPrefix:
+//
+//	mux.Handle("/debug/logdeb/", http.StripPrefix("/debug/logdeb", l.AdminHandler()))
+func (l *SLogger) AdminHandler() http.Handler {
+	return &sAdminHandler{l: l}
+}
+
+func (h *sAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prDeb("admin.go - ServeHTTP", r.Method, r.URL.Path)
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case path[0] == "" || (path[0] == "rules" && len(path) == 1):
+		if allowMethod(w, r, http.MethodGet) {
+			h.replyRules(w)
+		}
+	case path[0] == "rules" && len(path) == 2 && path[1] == "main":
+		if allowMethod(w, r, http.MethodPut) {
+			h.setMain(w, r)
+		}
+	case path[0] == "rules" && len(path) == 3 && path[1] == "writers":
+		if allowMethod(w, r, http.MethodGet, http.MethodPut) {
+			h.setWriter(w, r, path[2])
+		}
+	case path[0] == "rules" && len(path) > 4 && path[1] == "writers" && path[3] == "fncrules":
+		// function names can contain slashes
+		fnc := tFncName(strings.Join(path[4:], "/"))
+		if allowMethod(w, r, http.MethodPut, http.MethodDelete) {
+			h.setFncRule(w, r, path[2], fnc)
+		}
+	case path[0] == "flush" && len(path) == 1:
+		if allowMethod(w, r, http.MethodPost) {
+			if err := h.l.FlushContext(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case path[0] == "reopen" && len(path) == 1:
+		if allowMethod(w, r, http.MethodPost) {
+			if err := h.l.Reopen(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allowMethod: reply method not allowed when the request method is not one of methods
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// decode: decode the JSON request body, replying bad request on failure
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// replyJSON: reply v encoded in JSON
+func replyJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// replyRules: reply the current rules
+func (h *sAdminHandler) replyRules(w http.ResponseWriter) {
+	replyJSON(w, h.l.Rules())
+}
+
+// knownWriter: reply not found when the writer is not configured
+func (h *sAdminHandler) knownWriter(w http.ResponseWriter, writer string) bool {
+	if _, ok := h.l.writers[writer]; !ok {
+		http.Error(w, fmt.Sprintf("unknown writer %q", writer), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func (h *sAdminHandler) setMain(w http.ResponseWriter, r *http.Request) {
+	var req sMainRuleReq
+	if !decode(w, r, &req) {
+		return
+	}
+	err := h.l.updateRules(func(rules *sRules) error {
+		rules.main = req.merge(rules.main)
+		if req.UseFncRules != nil {
+			rules.useFncRules = *req.UseFncRules
+		}
+		return checkMainRule(rules.main)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.replyRules(w)
+}
+
+func (h *sAdminHandler) setWriter(w http.ResponseWriter, r *http.Request, writer string) {
+	if !h.knownWriter(w, writer) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		replyJSON(w, h.l.Rules().Writers[writer])
+		return
+	}
+	var req sRuleReq
+	if !decode(w, r, &req) {
+		return
+	}
+	err := h.l.updateRules(func(rules *sRules) error {
+		wr := rules.writers[writer]
+		wr.sBaseRule = req.merge(wr.sBaseRule)
+		rules.writers[writer] = wr
+		return checkRule(wr.Severity, wr.DebugLevel)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.replyRules(w)
+}
+
+func (h *sAdminHandler) setFncRule(w http.ResponseWriter, r *http.Request, writer string, fnc tFncName) {
+	if !h.knownWriter(w, writer) {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if err := h.l.RemoveFncRule(writer, fnc); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.replyRules(w)
+		return
+	}
+	var req sRuleReq
+	if !decode(w, r, &req) {
+		return
+	}
+	err := h.l.updateRules(func(rules *sRules) error {
+		wr := rules.writers[writer]
+		fr := req.merge(wr.FncRules[fnc])
+		if err := checkRule(fr.Severity, fr.DebugLevel); err != nil {
+			return err
+		}
+		fncRules := make(map[tFncName]sBaseRule, len(wr.FncRules)+1)
+		for f, r := range wr.FncRules {
+			fncRules[f] = r
+		}
+		fncRules[fnc] = fr
+		wr.FncRules = fncRules
+		rules.writers[writer] = wr
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.replyRules(w)
+}
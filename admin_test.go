@@ -0,0 +1,158 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// adminRequest: serve the request with the admin handler, decoding the replied rules
+func adminRequest(t *testing.T, l *SLogger, method string, path string, body string) (int, SRules) {
+	rr := httptest.NewRecorder()
+	l.AdminHandler().ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	var rules SRules
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &rules); err != nil {
+			t.Errorf("%s %s: invalid rules %q. ERR: %v", method, path, rr.Body.String(), err)
+		}
+	}
+	return rr.Code, rules
+}
+
+func TestAdminRules(t *testing.T) {
+	name := "TestAdminRules"
+	l := NewLogDeb(10, `{"main":{"sev":2}, "memory":{"sev":4}}`)
+	defer l.Destroy()
+	code, rules := adminRequest(t, l, http.MethodGet, "/rules", "")
+	if code != http.StatusOK || rules.Main != (SRule{SEVERROR, DLB}) || rules.Writers["memory"].SRule != (SRule{SEVINFO, DLB}) {
+		t.Errorf("%s: GET rules %d %v", name, code, rules)
+	}
+	code, rules = adminRequest(t, l, http.MethodPut, "/rules/main", `{"sev":3, "usefncrules":true}`)
+	if code != http.StatusOK || rules.Main.Severity != SEVWARN || !rules.UseFncRules {
+		t.Errorf("%s: PUT main %d %v", name, code, rules)
+	}
+	code, rules = adminRequest(t, l, http.MethodPut, "/rules/writers/memory", `{"sev":5, "dlev":2}`)
+	if code != http.StatusOK || rules.Writers["memory"].SRule != (SRule{SEVDEBUG, DLE}) || rules.MaxDebugLevel != DLE {
+		t.Errorf("%s: PUT writer %d %v", name, code, rules)
+	}
+	code, rules = adminRequest(t, l, http.MethodPut, "/rules/writers/memory/fncrules/pkg.Fnc", `{"sev":1}`)
+	if code != http.StatusOK || rules.Writers["memory"].FncRules["pkg.Fnc"] != (SRule{SEVFATAL, DLE}) {
+		t.Errorf("%s: PUT fncrule %d %v", name, code, rules)
+	}
+	code, rules = adminRequest(t, l, http.MethodDelete, "/rules/writers/memory/fncrules/pkg.Fnc", "")
+	if _, ok := rules.Writers["memory"].FncRules["pkg.Fnc"]; code != http.StatusOK || ok {
+		t.Errorf("%s: DELETE fncrule %d %v", name, code, rules)
+	}
+	for _, tr := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/rules", "", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/rules/main", `{"sev":0}`, http.StatusBadRequest},
+		{http.MethodPut, "/rules/main", `{"severity":3}`, http.StatusBadRequest},
+		{http.MethodPut, "/rules/writers/memory", `{"sev":9}`, http.StatusBadRequest},
+		{http.MethodPut, "/rules/writers/missing", `{"sev":3}`, http.StatusNotFound},
+		{http.MethodDelete, "/rules/writers/memory/fncrules/pkg.Fnc", "", http.StatusNotFound},
+		{http.MethodGet, "/missing", "", http.StatusNotFound},
+	} {
+		if code, _ := adminRequest(t, l, tr.method, tr.path, tr.body); code != tr.code {
+			t.Errorf("%s: %s %s expected %d, got %d", name, tr.method, tr.path, tr.code, code)
+		}
+	}
+	// the failed requests don't change the rules
+	if r := l.Rules(); r.Main.Severity != SEVWARN || r.Writers["memory"].Severity != SEVDEBUG {
+		t.Errorf("%s: rules changed by failed requests %v", name, r)
+	}
+}
+
+func TestAdminFlushReopen(t *testing.T) {
+	name := "TestAdminFlushReopen"
+	fnc := tFncName(name)
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "bufsize":4096, "fsync":"never", "filename":%q}}`, filename)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	srv := httptest.NewServer(http.StripPrefix("/debug/logdeb", l.AdminHandler()))
+	defer srv.Close()
+	post := func(path string) {
+		resp, err := http.Post(srv.URL+path, "", nil)
+		if err != nil {
+			t.Fatalf("%s: POST %s. ERR: %v", name, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("%s: POST %s expected %d, got %d", name, path, http.StatusNoContent, resp.StatusCode)
+		}
+	}
+	l.Deb(fnc, "test admin before rotation")
+	post("/debug/logdeb/flush")
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test admin before rotation"}, true},
+	}
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+	// rotate the file, the writer reopens it
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	post("/debug/logdeb/reopen")
+	l.Deb(fnc, "test admin after rotation")
+	l.Flush()
+	tmsgs = []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test admin before rotation"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test admin after rotation"}, true},
+	}
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestAdminWriterRules(t *testing.T) {
+	name := "TestAdminWriterRules"
+	l := NewLogDeb(10, `{"main":{"sev":2}, "memory":{"sev":5, "fncrules":{"pkg.Fnc":{"dlev":3}}}}`)
+	defer l.Destroy()
+	get := func(path string) (int, SWriterRules) {
+		rr := httptest.NewRecorder()
+		l.AdminHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var wr SWriterRules
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &wr); err != nil {
+				t.Errorf("%s: invalid writer rules %q. ERR: %v", name, rr.Body.String(), err)
+			}
+		}
+		return rr.Code, wr
+	}
+	code, wr := get("/rules/writers/memory")
+	if code != http.StatusOK || wr.SRule != (SRule{SEVDEBUG, DLB}) || wr.FncRules["pkg.Fnc"] != (SRule{SEVDEBUG, DLV}) {
+		t.Errorf("%s: GET writer %d %v", name, code, wr)
+	}
+	if code, _ := get("/rules/writers/missing"); code != http.StatusNotFound {
+		t.Errorf("%s: GET unknown writer expected %d, got %d", name, http.StatusNotFound, code)
+	}
+	// a partial body keeps the values not in the body
+	code, rules := adminRequest(t, l, http.MethodPut, "/rules/writers/memory", `{"dlev":3}`)
+	if code != http.StatusOK || rules.Writers["memory"].SRule != (SRule{SEVDEBUG, DLV}) {
+		t.Errorf("%s: PUT writer partial %d %v", name, code, rules)
+	}
+	code, rules = adminRequest(t, l, http.MethodPut, "/rules/writers/memory/fncrules/pkg.Fnc", `{"sev":3}`)
+	if code != http.StatusOK || rules.Writers["memory"].FncRules["pkg.Fnc"] != (SRule{SEVWARN, DLV}) {
+		t.Errorf("%s: PUT fncrule partial %d %v", name, code, rules)
+	}
+}
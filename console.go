@@ -15,7 +15,6 @@
 package logdeb
 
 import (
-	"fmt"
 	"log"
 	"os"
 )
@@ -23,8 +22,10 @@ import (
 const CONSSEP = "|||"
 
 type SConsoleWriter struct {
-	l          *log.Logger
+	l          *log.Logger // stdout logger
+	le         *log.Logger // stderr logger
 	flags      int
+	errSev     tSeverity // messages with severity up to errSev go to stderr
 	mainLogger *SLogger
 }
 
@@ -33,6 +34,7 @@ func NewConsoleWriter() ILogWriter {
 	cw := new(SConsoleWriter)
 	cw.flags = log.Ldate | log.Ltime
 	cw.l = log.New(os.Stdout, "", cw.flags)
+	cw.le = log.New(os.Stderr, "", cw.flags)
 	return cw
 }
 
@@ -43,6 +45,16 @@ func (cw *SConsoleWriter) getConfig(config map[string]interface{}) error {
 		if v, t := confout["flags"]; t {
 			cw.flags = int(v.(float64))
 			cw.l.SetFlags(cw.flags)
+			cw.le.SetFlags(cw.flags)
+		}
+		// errsev: severity threshold, messages with severity up to errsev
+		// (i.e. errsev 3 means warning, error and fatal) are sent to stderr
+		if v, t := confout["errsev"]; t {
+			cw.errSev = tSeverity(v.(float64))
+		}
+		// stderr: send all messages to stderr
+		if v, t := confout["stderr"]; t && v.(bool) {
+			cw.errSev = SEVDEBUG
 		}
 	}
 	return nil
@@ -63,13 +75,11 @@ func (cw *SConsoleWriter) Write(msg SLogMsg) error {
 	if !cw.mainLogger.MustWrite("console", msg) {
 		return nil
 	}
-	fnc := fmt.Sprintf("%v[%s]", msg.fnc, msg.sev)
-	if cw.flags > 0 {
-		cw.l.Println(CONSSEP, fnc, CONSSEP, msg.msg)
-	} else {
-		cw.l.Println(fnc, CONSSEP, msg.msg)
+	l := cw.l
+	if msg.sev <= cw.errSev {
+		l = cw.le
 	}
-	return nil
+	return writeMsg(l, cw.flags, CONSSEP, msg)
 }
 
 // implementing method. empty.
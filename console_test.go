@@ -26,28 +26,37 @@ type STLogMsg struct {
 	logit bool
 }
 
-var oldStout *os.File
-var w *os.File
-var outC chan string
+var oldStout, oldSterr *os.File
+var w, we *os.File
+var outC, errC chan string
+
+// capture output of a pipe in a separate goroutine so printing can't block indefinitely
+func capturePipe(r *os.File, c chan string) {
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	c <- buf.String()
+}
 
 func preTestConsole() {
-	var r *os.File
+	var r, re *os.File
 	oldStout = os.Stdout // keep backup of the real stdout
+	oldSterr = os.Stderr // keep backup of the real stderr
 	r, w, _ = os.Pipe()
+	re, we, _ = os.Pipe()
 	os.Stdout = w
+	os.Stderr = we
 	outC = make(chan string)
-	// copy the output in a separate goroutine so printing can't block indefinitely
-	go func() {
-		var buf bytes.Buffer
-		io.Copy(&buf, r)
-		outC <- buf.String()
-	}()
+	errC = make(chan string)
+	go capturePipe(r, outC)
+	go capturePipe(re, errC)
 }
 
 func postTestConsole() {
 	// back to normal state
 	w.Close()
+	we.Close()
 	os.Stdout = oldStout // restoring the real stdout
+	os.Stderr = oldSterr // restoring the real stderr
 }
 
 // Run console writer test and capture output from stdout
@@ -56,10 +65,24 @@ func runTestConsole(t *testing.T, name string, config string, tmsgs []STLogMsg)
 	executeTest(config, tmsgs)
 	postTestConsole()
 	out := <-outC
+	<-errC
 	prTest("CONSOLE OUTPUT:", out)
 	checkResult(t, out, name, CONSSEP, tmsgs)
 }
 
+// Run console writer test and capture output from stdout and stderr
+func runTestConsoleErr(t *testing.T, name string, config string, tmsgs []STLogMsg, tmsgsErr []STLogMsg) {
+	preTestConsole()
+	executeTest(config, tmsgs)
+	postTestConsole()
+	out := <-outC
+	errout := <-errC
+	prTest("CONSOLE OUTPUT:", out)
+	prTest("CONSOLE ERROR OUTPUT:", errout)
+	checkResult(t, out, name, CONSSEP, tmsgs)
+	checkResult(t, errout, name, CONSSEP, tmsgsErr)
+}
+
 func TestConsoleDeb1(t *testing.T) {
 	name := "TestConsoleDeb1"
 	fnc := tFncName(name)
@@ -144,3 +167,38 @@ func TestConsoleMainConf4(t *testing.T) {
 	}
 	runTestConsole(t, name, config, tmsgs[:])
 }
+
+func TestConsoleStderr1(t *testing.T) {
+	name := "TestConsoleStderr1"
+	fnc := tFncName(name)
+	config := `{"console":{"flags":0, "sev":5, "errsev":3}}`
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "debug on stdout"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVINFO, msg: "info on stdout"}, true},
+		// Don't write these on stdout because errsev is set to 3
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVWARN, msg: "warning on stderr"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVERROR, msg: "error on stderr"}, false},
+	}
+	tmsgsErr := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "debug on stdout"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVINFO, msg: "info on stdout"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVWARN, msg: "warning on stderr"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVERROR, msg: "error on stderr"}, true},
+	}
+	runTestConsoleErr(t, name, config, tmsgs[:], tmsgsErr[:])
+}
+
+func TestConsoleStderr2(t *testing.T) {
+	name := "TestConsoleStderr2"
+	fnc := tFncName(name)
+	config := `{"console":{"flags":0, "sev":5, "stderr":true}}`
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "debug on stderr"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVERROR, msg: "error on stderr"}, false},
+	}
+	tmsgsErr := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "debug on stderr"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVERROR, msg: "error on stderr"}, true},
+	}
+	runTestConsoleErr(t, name, config, tmsgs[:], tmsgsErr[:])
+}
@@ -16,35 +16,128 @@ package logdeb
 
 import (
 	"errors"
-	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const FILESEP = "|||"
 
-// an *os.File writer with locker.
+// File fsync policies
+const (
+	FSYNCNEVER = "never" // never commit the file to stable storage
+	FSYNCFLUSH = "flush" // commit on Flush
+	FSYNCCOUNT = "count" // commit every fsynccount messages
+	FSYNCERROR = "error" // commit on error and fatal messages
+)
+
+// File reopen backoff defaults
+const (
+	FILEDEFBACKOFFMIN = 100   // milliseconds
+	FILEDEFBACKOFFMAX = 30000 // milliseconds
+)
+
+// an *os.File writer with locker. With lock set the writes are also
+// protected by an advisory file lock, shared with other processes.
 type MuxWriter struct {
 	sync.Mutex
-	fd *os.File
+	fd   *os.File
+	lock bool
+}
+
+// an open log file. With bufSize set the records are kept in buf and
+// written when the buffer is full or flushed, a record is never split.
+type sFileOut struct {
+	l       *log.Logger
+	mw      *MuxWriter
+	buf     []byte
+	msgs    []SLogMsg // messages of the buffered records
+	bufSize int
+	count   int // messages written since the last fsync
 }
 
+// SFileWriter writes messages on files. The file name can be a pattern
+// splitting the messages in many files, see fileNameFor
 type SFileWriter struct {
-	l          *log.Logger
+	sync.Mutex
 	mainLogger *SLogger
 	fileName   string
 	flags      int
-	mw         *MuxWriter
+	perm       os.FileMode          // permissions of new files
+	mkdir      bool                 // create the missing directories
+	symlink    string               // symlink to the active file
+	lock       bool                 // use advisory file lock for multi-process appends
+	bufSize    int                  // write buffer size, 0 means unbuffered
+	flushEvery time.Duration        // flush interval of the write buffers
+	fsync      string               // fsync policy
+	fsyncCount int                  // messages between fsync with count policy
+	files      map[string]*sFileOut // open files by name
+	reopen     map[string]*sBackoff // reopen backoff of files failed to open or write
+	backoff    sBackoff             // backoff configuration
+	session    string               // session Id and date of the open files,
+	date       string               // used when the file name contains them
+	done       chan bool            // stop the flush timer
+	wg         sync.WaitGroup
 }
 
-// write to os.File.
+// write to os.File. The record is written with one call, with O_APPEND
+// and the file lock records of concurrent processes never interleave.
 func (mw *MuxWriter) Write(b []byte) (int, error) {
 	mw.Lock()
 	defer mw.Unlock()
+	if mw.lock {
+		if err := lockFile(mw.fd); err != nil {
+			return 0, err
+		}
+		defer unlockFile(mw.fd)
+	}
 	return mw.fd.Write(b)
 }
 
+// write the record in the buffer, flushing the buffer first when full
+func (out *sFileOut) Write(b []byte) (int, error) {
+	if out.bufSize == 0 {
+		return out.mw.Write(b)
+	}
+	if len(out.buf)+len(b) > out.bufSize {
+		if err := out.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if len(b) > out.bufSize {
+		return out.mw.Write(b)
+	}
+	out.buf = append(out.buf, b...)
+	return len(b), nil
+}
+
+// flush: write the buffered records with one call. On failure the
+// records are kept, they are reported by flushFailed
+func (out *sFileOut) flush() error {
+	if len(out.buf) == 0 {
+		return nil
+	}
+	if _, err := out.mw.Write(out.buf); err != nil {
+		return err
+	}
+	out.buf = out.buf[:0]
+	out.msgs = out.msgs[:0]
+	return nil
+}
+
+// sync: flush and commit the file to stable storage
+func (out *sFileOut) sync() error {
+	if err := out.flush(); err != nil {
+		return err
+	}
+	out.count = 0
+	return out.mw.fd.Sync()
+}
+
 // SetFd: set file descriptor
 func (mw *MuxWriter) SetFd(fd *os.File) {
 	if mw.fd != nil {
@@ -56,9 +149,13 @@ func (mw *MuxWriter) SetFd(fd *os.File) {
 // NewFileWriter: create SFileWriter returning as ILogWriter.
 func NewFileWriter() ILogWriter {
 	fw := new(SFileWriter)
-	fw.mw = new(MuxWriter)
 	fw.flags = log.Ldate | log.Ltime
-	fw.l = log.New(fw.mw, "", fw.flags)
+	fw.perm = 0660
+	fw.fsync = FSYNCFLUSH
+	fw.files = make(map[string]*sFileOut)
+	fw.reopen = make(map[string]*sBackoff)
+	fw.backoff.min = FILEDEFBACKOFFMIN * time.Millisecond
+	fw.backoff.max = FILEDEFBACKOFFMAX * time.Millisecond
 	return fw
 }
 
@@ -71,29 +168,182 @@ func (fw *SFileWriter) getConfig(config map[string]interface{}) error {
 		}
 		if v, t := confout["flags"]; t {
 			fw.flags = int(v.(float64))
-			fw.l.SetFlags(fw.flags)
 		}
-
+		if v, t := confout["perm"]; t {
+			// octal string like "0640" or number
+			switch p := v.(type) {
+			case string:
+				perm, err := strconv.ParseUint(p, 8, 32)
+				if err != nil {
+					return errors.New("invalid perm " + p)
+				}
+				fw.perm = os.FileMode(perm)
+			case float64:
+				fw.perm = os.FileMode(p)
+			}
+			fw.perm &= os.ModePerm
+		}
+		if v, t := confout["mkdir"]; t {
+			fw.mkdir = v.(bool)
+		}
+		if v, t := confout["symlink"]; t {
+			fw.symlink = v.(string)
+		}
+		if v, t := confout["lock"]; t {
+			fw.lock = v.(bool)
+		}
+		if v, t := confout["bufsize"]; t {
+			fw.bufSize = int(v.(float64))
+		}
+		if v, t := confout["flushinterval"]; t {
+			fw.flushEvery = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["fsync"]; t {
+			fw.fsync = strings.ToLower(v.(string))
+		}
+		if v, t := confout["fsynccount"]; t {
+			fw.fsyncCount = int(v.(float64))
+		}
+		if v, t := confout["backoffmin"]; t {
+			fw.backoff.min = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["backoffmax"]; t {
+			fw.backoff.max = time.Duration(v.(float64)) * time.Millisecond
+		}
+	}
+	switch fw.fsync {
+	case FSYNCNEVER, FSYNCFLUSH, FSYNCERROR:
+	case FSYNCCOUNT:
+		if fw.fsyncCount < 1 {
+			return errors.New("fsynccount not configured")
+		}
+	default:
+		return errors.New("unknown fsync policy " + fw.fsync)
 	}
 	return nil
 }
 
-// openFile, open the file for writing
-func (fw *SFileWriter) openFile() error {
-	prDeb("file.go - openFile", "Begin")
-	// open the file
-	var err error
-	if fw.mw.fd != nil {
-		fw.mw.fd.Close()
+// fileNameFor: file name of the message, replacing in the file name pattern
+// - {sev}:     severity name, like error or debug
+// - {level}:   severity name, debug messages include the debug level, like debug3
+// - {session}: log session Id
+// - {date}:    message date, like 20140521
+func (fw *SFileWriter) fileNameFor(msg SLogMsg) string {
+	if !strings.Contains(fw.fileName, "{") {
+		return fw.fileName
+	}
+	level := msg.sev.name()
+	if msg.sev == SEVDEBUG {
+		level = level + strconv.Itoa(int(msg.debLev))
+	}
+	return strings.NewReplacer(
+		"{sev}", msg.sev.name(),
+		"{level}", level,
+		"{session}", msg.sessionId,
+		"{date}", msg.ts.Format("20060102"),
+	).Replace(fw.fileName)
+}
+
+// switchFiles: close the open files when the session Id or the date used
+// by the file name change, the new files are opened by Write
+func (fw *SFileWriter) switchFiles(msg SLogMsg) {
+	date := msg.ts.Format("20060102")
+	if (strings.Contains(fw.fileName, "{session}") && msg.sessionId != fw.session) ||
+		(strings.Contains(fw.fileName, "{date}") && date != fw.date) {
+		fw.closeFiles()
+	}
+	fw.session = msg.sessionId
+	fw.date = date
+}
+
+// openFile, open the file for writing. After a failure the file is
+// not opened again until the backoff time is elapsed
+func (fw *SFileWriter) openFile(fileName string) (*sFileOut, error) {
+	prDeb("file.go - openFile", "Open file "+fileName)
+	if b, ok := fw.reopen[fileName]; ok && b.waiting() {
+		return nil, errors.New("waiting to reopen file " + fileName)
+	}
+	if fw.mkdir {
+		// directories can be traversed by who can read the files
+		dirPerm := fw.perm | (fw.perm&0444)>>2
+		if err := os.MkdirAll(filepath.Dir(fileName), dirPerm); err != nil {
+			fw.failFile(fileName)
+			return nil, err
+		}
 	}
-	prDeb("file.go - openFile", "Open file "+fw.fileName)
-	fw.mw.fd, err = os.OpenFile(fw.fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	fd, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, fw.perm)
 	if err != nil {
 		prDeb("file.go - openFile", "File error. Err: ", err)
+		fw.failFile(fileName)
+		return nil, err
+	}
+	prDeb("file.go - openFile", "File opened. Fd: ", fd)
+	out := &sFileOut{mw: &MuxWriter{fd: fd, lock: fw.lock}, bufSize: fw.bufSize}
+	out.l = log.New(out, "", fw.flags)
+	fw.files[fileName] = out
+	if len(fw.symlink) > 0 {
+		// the message can be written also when the symlink fails
+		if err := fw.linkFile(fileName); err != nil {
+			fw.mainLogger.ReportError("file", err)
+		}
+	}
+	return out, nil
+}
+
+// linkFile: point the symlink to the file, replacing it atomically
+func (fw *SFileWriter) linkFile(fileName string) error {
+	// the target is relative to the symlink directory, absolute when it can not be
+	target, err := filepath.Abs(fileName)
+	if err != nil {
 		return err
 	}
-	prDeb("file.go - openFile", "File opened. Fd: ", fw.mw.fd)
-	return nil
+	if dir, err := filepath.Abs(filepath.Dir(fw.symlink)); err == nil {
+		if rel, err := filepath.Rel(dir, target); err == nil {
+			target = rel
+		}
+	}
+	if cur, err := os.Readlink(fw.symlink); err == nil && cur == target {
+		return nil
+	}
+	tmp := fw.symlink + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fw.symlink)
+}
+
+// failFile: close the file and compute the next reopen time
+func (fw *SFileWriter) failFile(fileName string) {
+	if out, ok := fw.files[fileName]; ok {
+		out.mw.fd.Close()
+		delete(fw.files, fileName)
+	}
+	b, ok := fw.reopen[fileName]
+	if !ok {
+		b = &sBackoff{min: fw.backoff.min, max: fw.backoff.max}
+		fw.reopen[fileName] = b
+	}
+	b.fail()
+}
+
+// flushFailed: report the buffered messages as failed and close the file
+func (fw *SFileWriter) flushFailed(fileName string, out *sFileOut, err error) {
+	fw.mainLogger.writeFailed("file", err, out.msgs...)
+	fw.failFile(fileName)
+}
+
+// closeFiles: close all the open files
+func (fw *SFileWriter) closeFiles() {
+	for fileName, out := range fw.files {
+		prDeb("file.go - closeFiles", "close the file "+fileName)
+		if err := out.flush(); err != nil {
+			fw.flushFailed(fileName, out, err)
+			continue
+		}
+		out.mw.fd.Close()
+		delete(fw.files, fileName)
+	}
 }
 
 // Init file logger.
@@ -105,39 +355,121 @@ func (fw *SFileWriter) Init(logger *SLogger, config map[string]interface{}) erro
 	if len(fw.fileName) == 0 {
 		return errors.New("filename not configured")
 	}
+	if fw.lock && !fileLockSupported {
+		return errors.New("file lock not supported")
+	}
+	if fw.bufSize > 0 && fw.flushEvery > 0 {
+		fw.done = make(chan bool)
+		fw.wg.Add(1)
+		go fw.timer()
+	}
 	return nil
 }
 
+// timer: flush the write buffers every flushEvery
+func (fw *SFileWriter) timer() {
+	defer fw.wg.Done()
+	ticker := time.NewTicker(fw.flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-ticker.C:
+			fw.Lock()
+			for fileName, out := range fw.files {
+				if err := out.flush(); err != nil {
+					fw.flushFailed(fileName, out, err)
+				}
+			}
+			fw.Unlock()
+		}
+	}
+}
+
 // Write message on the file.
 func (fw *SFileWriter) Write(msg SLogMsg) error {
 	prDeb("file.go - Write", "MSG: ", msg)
 	if !fw.mainLogger.MustWrite("file", msg) {
 		return nil
 	}
-	if fw.mw.fd == nil {
-		if err := fw.openFile(); err != nil {
+	fw.Lock()
+	defer fw.Unlock()
+	fw.switchFiles(msg)
+	fileName := fw.fileNameFor(msg)
+	out, ok := fw.files[fileName]
+	if !ok {
+		var err error
+		if out, err = fw.openFile(fileName); err != nil {
 			return err
 		}
 	}
 	prDeb("file.go - Write", "Write message to file")
-	fnc := fmt.Sprintf("%v[%s]", msg.fnc, msg.sev)
-	if fw.flags > 0 {
-		fw.l.Println(FILESEP, fnc, FILESEP, msg.msg)
-	} else {
-		fw.l.Println(fnc, FILESEP, msg.msg)
+	err := writeMsg(out.l, fw.flags, FILESEP, msg)
+	if err == nil {
+		// the buffer is empty when the record is written directly
+		if len(out.buf) > 0 {
+			out.msgs = append(out.msgs, msg)
+		}
+		out.count++
+		switch {
+		case fw.fsync == FSYNCERROR && msg.sev <= SEVERROR:
+			err = out.sync()
+		case fw.fsync == FSYNCCOUNT && out.count >= fw.fsyncCount:
+			err = out.sync()
+		}
 	}
+	if err != nil {
+		// the file is reopened by the next write, after the backoff time.
+		// The message is reported by the caller, the buffered ones here
+		if len(out.msgs) > 0 {
+			fw.mainLogger.writeFailed("file", err, out.msgs...)
+		}
+		fw.failFile(fileName)
+		return err
+	}
+	delete(fw.reopen, fileName)
 	return nil
 }
 
-// implementing method. empty.
+// Destroy stop the flush timer and close the files.
 func (fw *SFileWriter) Destroy() {
-	prDeb("Destroy", "close the file")
-	fw.mw.fd.Close()
+	if fw.done != nil {
+		close(fw.done)
+		fw.wg.Wait()
+		fw.done = nil
+	}
+	fw.Lock()
+	defer fw.Unlock()
+	fw.closeFiles()
 }
 
-// implementing method. empty.
+// Reopen close the files, they are reopened by the next write.
+// Used after the files have been moved by an external log rotation
+func (fw *SFileWriter) Reopen() error {
+	fw.Lock()
+	defer fw.Unlock()
+	fw.closeFiles()
+	fw.reopen = make(map[string]*sBackoff)
+	return nil
+}
+
+// Flush write the buffers, and commit the files to stable storage
+// unless the fsync policy is never.
 func (fw *SFileWriter) Flush() {
-	fw.mw.fd.Sync()
+	fw.Lock()
+	defer fw.Unlock()
+	for fileName, out := range fw.files {
+		var err error
+		if fw.fsync == FSYNCNEVER {
+			err = out.flush()
+		} else {
+			err = out.sync()
+		}
+		if err != nil {
+			fw.flushFailed(fileName, out, err)
+		}
+	}
 }
 
 func init() {
@@ -16,13 +16,28 @@ package logdeb
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// Read the file written by the test
+func readTestFile(filename string) string {
+	f, err := os.OpenFile(filename, os.O_RDONLY, 0660)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	outb, _ := ioutil.ReadAll(bufio.NewReader(f))
+	return string(outb)
+}
+
 func runTestFile(t *testing.T, name string, config string, tmsgs []STLogMsg) {
 	var unconf map[string]interface{}
 	var filename string
@@ -43,12 +58,7 @@ func runTestFile(t *testing.T, name string, config string, tmsgs []STLogMsg) {
 	// Delete the log file to get a clean config
 	os.Remove(filename)
 	executeTest(config, tmsgs)
-	f, err := os.OpenFile(filename, os.O_RDONLY, 0660)
-	if err != nil {
-		fmt.Println(err)
-	}
-	outb, err := ioutil.ReadAll(bufio.NewReader(f))
-	out := string(outb)
+	out := readTestFile(filename)
 	prTest("FILE OUTPUT:", out)
 	checkResult(t, out, name, FILESEP, tmsgs)
 }
@@ -83,3 +93,394 @@ func TestFileDeb3(t *testing.T) {
 	}
 	runTestFile(t, name, config, tmsgs[:])
 }
+
+func TestFileSplit(t *testing.T) {
+	name := "TestFileSplit"
+	fnc := tFncName(name)
+	dir := t.TempDir()
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "dlev":2, "filename":%q}}`, filepath.Join(dir, "app.{sev}.log"))
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split error", sev: SEVERROR}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split info", sev: SEVINFO}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split debug 1"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split debug 2", debLev: 2}, true},
+	}
+	executeTest(config, tmsgs)
+	checkResult(t, readTestFile(filepath.Join(dir, "app.error.log")), name, FILESEP, []STLogMsg{tmsgs[0], {tmsgs[1].SLogMsg, false}})
+	checkResult(t, readTestFile(filepath.Join(dir, "app.info.log")), name, FILESEP, []STLogMsg{tmsgs[1], {tmsgs[0].SLogMsg, false}})
+	checkResult(t, readTestFile(filepath.Join(dir, "app.debug.log")), name, FILESEP, tmsgs[2:])
+}
+
+func TestFileSplitLevel(t *testing.T) {
+	name := "TestFileSplitLevel"
+	fnc := tFncName(name)
+	dir := t.TempDir()
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "dlev":2, "filename":%q}}`, filepath.Join(dir, "app.{level}.log"))
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split warning", sev: SEVWARN}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split debug 1"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file split debug 2", debLev: 2}, true},
+	}
+	executeTest(config, tmsgs)
+	checkResult(t, readTestFile(filepath.Join(dir, "app.warning.log")), name, FILESEP, tmsgs[:1])
+	checkResult(t, readTestFile(filepath.Join(dir, "app.debug1.log")), name, FILESEP, []STLogMsg{tmsgs[1], {tmsgs[2].SLogMsg, false}})
+	checkResult(t, readTestFile(filepath.Join(dir, "app.debug2.log")), name, FILESEP, []STLogMsg{tmsgs[2], {tmsgs[1].SLogMsg, false}})
+}
+
+func TestFileSession(t *testing.T) {
+	name := "TestFileSession"
+	fnc := tFncName(name)
+	dir := t.TempDir()
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "filename":%q}}`, filepath.Join(dir, "job-{session}-{date}.log"))
+	l := NewLogDeb(10, config)
+	l.SetSessionId("job1")
+	l.Deb(fnc, "test file session 1")
+	l.SetSessionId("job2")
+	l.Deb(fnc, "test file session 2")
+	l.Destroy()
+	date := time.Now().Format("20060102")
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file session 1"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file session 2"}, false},
+	}
+	checkResult(t, readTestFile(filepath.Join(dir, "job-job1-"+date+".log")), name, FILESEP, tmsgs)
+	tmsgs[0].logit, tmsgs[1].logit = false, true
+	checkResult(t, readTestFile(filepath.Join(dir, "job-job2-"+date+".log")), name, FILESEP, tmsgs)
+	if fw := l.Writer("file").(*SFileWriter); len(fw.files) != 0 {
+		t.Errorf("%s: files not closed %v", name, fw.files)
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	name := "TestFileLock"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "shared.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "lock":true, "filename":%q}}`, filename)
+	// lock the file as another process would do
+	fd, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0660)
+	if err != nil {
+		t.Fatalf("%s: can not open file. ERR: %s", name, err)
+	}
+	defer fd.Close()
+	if err := lockFile(fd); err != nil {
+		t.Skipf("%s: file lock not available. ERR: %s", name, err)
+	}
+	l := NewLogDeb(10, config)
+	l.Deb(fnc, "test file lock")
+	time.Sleep(20 * time.Millisecond)
+	if out := readTestFile(filename); out != "" {
+		t.Errorf("%s: message written while the file is locked: %q", name, out)
+	}
+	unlockFile(fd)
+	l.Destroy()
+	checkResult(t, readTestFile(filename), name, FILESEP, []STLogMsg{STLogMsg{SLogMsg{fnc: fnc, msg: "test file lock"}, true}})
+}
+
+func TestFileLockConcurrent(t *testing.T) {
+	name := "TestFileLockConcurrent"
+	filename := filepath.Join(t.TempDir(), "shared.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "lock":true, "filename":%q}}`, filename)
+	// two loggers with their own file descriptors, like two processes
+	var tmsgs []STLogMsg
+	done := make(chan bool)
+	for w := 0; w < 2; w++ {
+		fnc := tFncName(fmt.Sprintf("%s.w%d", name, w))
+		for i := 0; i < 50; i++ {
+			msg := fmt.Sprintf("%d %s", i, strings.Repeat(string(rune('a'+w)), 8192))
+			tmsgs = append(tmsgs, STLogMsg{SLogMsg{fnc: fnc, msg: msg}, true})
+		}
+		go func(tmsgs []STLogMsg) {
+			executeTest(config, tmsgs)
+			done <- true
+		}(tmsgs[w*50:])
+	}
+	<-done
+	<-done
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestFileBuffered(t *testing.T) {
+	name := "TestFileBuffered"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "buffered.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "bufsize":4096, "fsync":"error", "filename":%q}}`, filename)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	// write directly to the writer to check the buffer state
+	fw := l.Writer("file").(*SFileWriter)
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file buffered debug", sev: SEVDEBUG, debLev: DLB, ts: time.Now()}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file buffered info", sev: SEVINFO, ts: time.Now()}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file buffered error", sev: SEVERROR, ts: time.Now()}, true},
+	}
+	fw.Write(tmsgs[0].SLogMsg)
+	fw.Write(tmsgs[1].SLogMsg)
+	if out := readTestFile(filename); out != "" {
+		t.Errorf("%s: buffered messages written %q", name, out)
+	}
+	// the error message flushes the buffer
+	fw.Write(tmsgs[2].SLogMsg)
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+	tmsgs = []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file buffered flush", sev: SEVINFO, ts: time.Now()}, true},
+	}
+	fw.Write(tmsgs[0].SLogMsg)
+	fw.Flush()
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestFileBufferedCount(t *testing.T) {
+	name := "TestFileBufferedCount"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "buffered.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "bufsize":4096, "fsync":"count", "fsynccount":2, "filename":%q}}`, filename)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	fw := l.Writer("file").(*SFileWriter)
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file count 1", sev: SEVINFO, ts: time.Now()}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file count 2", sev: SEVINFO, ts: time.Now()}, true},
+	}
+	fw.Write(tmsgs[0].SLogMsg)
+	if out := readTestFile(filename); out != "" {
+		t.Errorf("%s: buffered messages written %q", name, out)
+	}
+	fw.Write(tmsgs[1].SLogMsg)
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestFileFlushInterval(t *testing.T) {
+	name := "TestFileFlushInterval"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "buffered.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "bufsize":4096, "flushinterval":10, "filename":%q}}`, filename)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file flush interval"}, true},
+	}
+	l.Deb(fnc, tmsgs[0].msg)
+	for i := 0; i < 100 && readTestFile(filename) == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestFileFailure(t *testing.T) {
+	name := "TestFileFailure"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "missing", "app.log")
+	config := fmt.Sprintf(`{"main":{"fallback":"stderr"}, "file":{"flags":0, "sev":5, "backoffmin":60000, "filename":%q}}`, filename)
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file failure 1"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file failure 2"}, true},
+	}
+	var errs []string
+	preTestConsole()
+	l := NewLogDeb(10, config)
+	l.SetErrorHandler(func(writer string, err error) {
+		errs = append(errs, writer+": "+err.Error())
+	})
+	for _, tm := range tmsgs {
+		l.Deb(tm.fnc, tm.msg)
+	}
+	l.Destroy()
+	postTestConsole()
+	<-outC
+	out := <-errC
+	// the messages are written on stderr, with date and time
+	for _, tm := range tmsgs {
+		if !strings.Contains(out, fmt.Sprintf(" %s[%s] %s %s\n", tm.fnc, tSeverity(SEVDEBUG), CONSSEP, tm.msg)) {
+			t.Errorf("%s: message %q not written on stderr %q", name, tm.msg, out)
+		}
+	}
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "file: open ") || errs[1] != "file: waiting to reopen file "+filename {
+		t.Errorf("%s: unexpected errors %q", name, errs)
+	}
+	if d := l.DroppedMessages()["file"]; d != 2 {
+		t.Errorf("%s: expected 2 dropped messages, got %d", name, d)
+	}
+}
+
+func TestFileInitFailure(t *testing.T) {
+	name := "TestFileInitFailure"
+	fnc := tFncName(name)
+	var errs []string
+	preTestConsole()
+	// the file writer without filename fails to initialize
+	l := NewLogDeb(10, `{"main":{"fallback":"stderr"}, "file":{"flags":0, "sev":5}}`)
+	l.SetErrorHandler(func(writer string, err error) {
+		errs = append(errs, writer+": "+err.Error())
+	})
+	l.Deb(fnc, "test file init failure")
+	l.Destroy()
+	postTestConsole()
+	<-outC
+	out := <-errC
+	if !strings.Contains(out, "test file init failure") {
+		t.Errorf("%s: message not written on stderr %q", name, out)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "filename not configured") {
+		t.Errorf("%s: unexpected errors %q", name, errs)
+	}
+	if d := l.DroppedMessages()["file"]; d != 1 {
+		t.Errorf("%s: expected 1 dropped message, got %d", name, d)
+	}
+}
+
+func TestFileFlushFailure(t *testing.T) {
+	name := "TestFileFlushFailure"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "buffered.log")
+	config := fmt.Sprintf(`{"main":{"fallback":"stderr"}, "file":{"flags":0, "sev":5, "bufsize":4096, "fsync":"never", "filename":%q}}`, filename)
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file flush failure 1", sev: SEVINFO, ts: time.Now()}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file flush failure 2", sev: SEVINFO, ts: time.Now()}, true},
+	}
+	var errs []string
+	preTestConsole()
+	l := NewLogDeb(10, config)
+	l.SetErrorHandler(func(writer string, err error) {
+		errs = append(errs, writer+": "+err.Error())
+	})
+	fw := l.Writer("file").(*SFileWriter)
+	for _, tm := range tmsgs {
+		fw.Write(tm.SLogMsg)
+	}
+	// the buffered records can not be written on the closed file
+	fw.files[filename].mw.fd.Close()
+	fw.Flush()
+	l.Destroy()
+	postTestConsole()
+	<-outC
+	out := <-errC
+	for _, tm := range tmsgs {
+		if !strings.Contains(out, tm.msg) {
+			t.Errorf("%s: message %q not written on stderr %q", name, tm.msg, out)
+		}
+	}
+	if len(errs) != 1 || len(fw.files) != 0 {
+		t.Errorf("%s: unexpected errors %q, open files %d", name, errs, len(fw.files))
+	}
+	if d := l.DroppedMessages()["file"]; d != 2 {
+		t.Errorf("%s: expected 2 dropped messages, got %d", name, d)
+	}
+}
+
+func TestFilePermMkdirSymlink(t *testing.T) {
+	name := "TestFilePermMkdirSymlink"
+	fnc := tFncName(name)
+	dir := t.TempDir()
+	link := filepath.Join(dir, "current.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "perm":"0600", "mkdir":true, "symlink":%q, "filename":%q}}`,
+		link, filepath.Join(dir, "logs", "{session}", "app.log"))
+	l := NewLogDeb(10, config)
+	l.SetSessionId("s1")
+	l.Deb(fnc, "test file session 1")
+	l.SetSessionId("s2")
+	l.Deb(fnc, "test file session 2")
+	l.Destroy()
+	fi, err := os.Stat(filepath.Join(dir, "logs", "s2", "app.log"))
+	if err != nil {
+		t.Fatalf("%s: file not created. ERR: %s", name, err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("%s: expected permissions 0600, got %o", name, fi.Mode().Perm())
+	}
+	target, err := os.Readlink(link)
+	if err != nil || target != filepath.Join("logs", "s2", "app.log") {
+		t.Errorf("%s: unexpected symlink target %q. ERR: %v", name, target, err)
+	}
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file session 1"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file session 2"}, true},
+	}
+	checkResult(t, readTestFile(link), name, FILESEP, tmsgs)
+}
+
+func TestFileSymlinkRelative(t *testing.T) {
+	name := "TestFileSymlinkRelative"
+	fnc := tFncName(name)
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file name is relative to the working directory, the symlink is absolute
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	link := filepath.Join(dir, "links", "current.log")
+	if err := os.Mkdir(filepath.Dir(link), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "symlink":%q, "filename":"app.log"}}`, link)
+	l := NewLogDeb(10, config)
+	l.Deb(fnc, "test file symlink relative")
+	l.Destroy()
+	target, err := os.Readlink(link)
+	if err != nil || target != filepath.Join("..", "app.log") {
+		t.Errorf("%s: unexpected symlink target %q. ERR: %v", name, target, err)
+	}
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file symlink relative"}, true},
+	}
+	checkResult(t, readTestFile(link), name, FILESEP, tmsgs)
+}
+
+func TestFileFatalFlush(t *testing.T) {
+	name := "TestFileFatalFlush"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "fatal.log")
+	config := fmt.Sprintf(`{"main":{"syncfatal":true}, "file":{"flags":0, "sev":5, "bufsize":4096, "fsync":"never", "filename":%q}}`, filename)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	l.Fatal(fnc, "test file fatal flush")
+	// the buffered message is written when Fatal returns
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVFATAL, msg: "test file fatal flush"}, true},
+	}
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestFileFlushPending(t *testing.T) {
+	name := "TestFileFlushPending"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "pending.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "bufsize":65536, "fsync":"never", "filename":%q}}`, filename)
+	l := NewLogDeb(1000, config)
+	defer l.Destroy()
+	var tmsgs []STLogMsg
+	for i := 0; i < 500; i++ {
+		tmsgs = append(tmsgs, STLogMsg{SLogMsg{fnc: fnc, msg: fmt.Sprintf("test file flush pending %d", i)}, true})
+		l.Deb(fnc, tmsgs[i].msg)
+	}
+	// the queued messages are written when Flush returns
+	l.Flush()
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
+
+func TestFileFlushContext(t *testing.T) {
+	name := "TestFileFlushContext"
+	fnc := tFncName(name)
+	filename := filepath.Join(t.TempDir(), "pending.log")
+	config := fmt.Sprintf(`{"file":{"flags":0, "sev":5, "filename":%q}}`, filename)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	l.Deb(fnc, "test file flush context")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.FlushContext(ctx); err != context.Canceled {
+		t.Errorf("%s: expected context.Canceled, got %v", name, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.FlushContext(ctx); err != nil {
+		t.Errorf("%s: unexpected error %v", name, err)
+	}
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test file flush context"}, true},
+	}
+	checkResult(t, readTestFile(filename), name, FILESEP, tmsgs)
+}
@@ -0,0 +1,35 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package logdeb
+
+import (
+	"errors"
+	"os"
+)
+
+const fileLockSupported = false
+
+// lockFile: advisory file lock is not supported
+func lockFile(fd *os.File) error {
+	return errors.New("file lock not supported")
+}
+
+// unlockFile: advisory file lock is not supported
+func unlockFile(fd *os.File) error {
+	return errors.New("file lock not supported")
+}
@@ -0,0 +1,240 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// GELF writer defaults
+const (
+	GELFDEFCHUNKSIZE = 1420 // UDP chunk size, fits in the usual ethernet MTU
+	GELFMAXCHUNKS    = 128  // maximum number of chunks of a message
+)
+
+// SGelfWriter sends GELF 1.1 messages to Graylog over UDP or TCP
+type SGelfWriter struct {
+	sync.Mutex
+	mainLogger  *SLogger
+	network     string // udp or tcp
+	addr        string // host:port
+	host        string
+	compression string // gzip, zlib or none. UDP only
+	chunkSize   int
+	fields      map[string]interface{} // additional fields sent with every message
+	conn        net.Conn
+}
+
+// NewGelfWriter: create SGelfWriter returning as ILogWriter.
+func NewGelfWriter() ILogWriter {
+	gw := new(SGelfWriter)
+	gw.network = "udp"
+	gw.host, _ = os.Hostname()
+	gw.compression = "gzip"
+	gw.chunkSize = GELFDEFCHUNKSIZE
+	gw.fields = make(map[string]interface{})
+	return gw
+}
+
+// getConfig: extract configuration
+func (gw *SGelfWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["network"]; t {
+			gw.network = strings.ToLower(v.(string))
+		}
+		if v, t := confout["addr"]; t {
+			gw.addr = v.(string)
+		}
+		if v, t := confout["host"]; t {
+			gw.host = v.(string)
+		}
+		if v, t := confout["compression"]; t {
+			gw.compression = strings.ToLower(v.(string))
+		}
+		if v, t := confout["chunksize"]; t {
+			gw.chunkSize = int(v.(float64))
+		}
+		if v, t := confout["fields"]; t {
+			for fk, fv := range v.(map[string]interface{}) {
+				gw.fields["_"+strings.TrimPrefix(fk, "_")] = fv
+			}
+		}
+	}
+	if gw.network != "udp" && gw.network != "tcp" {
+		return fmt.Errorf("unsupported network %q", gw.network)
+	}
+	if gw.compression != "gzip" && gw.compression != "zlib" && gw.compression != "none" {
+		return fmt.Errorf("unknown compression %q", gw.compression)
+	}
+	// 12 bytes are used by the chunk header
+	if gw.chunkSize <= 12 {
+		return fmt.Errorf("chunk size %d too small", gw.chunkSize)
+	}
+	return nil
+}
+
+// Init gelf logger.
+func (gw *SGelfWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	gw.mainLogger = logger
+	if err := gw.getConfig(config); err != nil {
+		return err
+	}
+	if len(gw.addr) == 0 {
+		return errors.New("addr not configured")
+	}
+	return nil
+}
+
+// encode: build the GELF JSON message. SLogMsg fields are sent as
+// additional fields together with the configured ones
+func (gw *SGelfWriter) encode(msg SLogMsg) ([]byte, error) {
+	gm := make(map[string]interface{}, len(gw.fields)+9)
+	for fk, fv := range gw.fields {
+		gm[fk] = fv
+	}
+	gm["version"] = "1.1"
+	gm["host"] = gw.host
+	gm["short_message"] = msg.msg
+	gm["timestamp"] = float64(msg.ts.UnixNano()/1e6) / 1e3
+	gm["level"] = syslogSeverity(msg.sev)
+	gm["_fnc"] = string(msg.fnc)
+	gm["_sev"] = msg.sev.name()
+	if msg.debLev > 0 {
+		gm["_debug_level"] = msg.debLev
+	}
+	if msg.sessionId != "" {
+		gm["_session_id"] = msg.sessionId
+	}
+	return json.Marshal(gm)
+}
+
+// compress: compress the message for UDP
+func (gw *SGelfWriter) compress(b []byte) []byte {
+	var buf bytes.Buffer
+	switch gw.compression {
+	case "gzip":
+		zw := gzip.NewWriter(&buf)
+		zw.Write(b)
+		zw.Close()
+	case "zlib":
+		zw := zlib.NewWriter(&buf)
+		zw.Write(b)
+		zw.Close()
+	default:
+		return b
+	}
+	return buf.Bytes()
+}
+
+// chunks: split the message in GELF chunks when it is bigger than chunkSize
+func (gw *SGelfWriter) chunks(b []byte) ([][]byte, error) {
+	if len(b) <= gw.chunkSize {
+		return [][]byte{b}, nil
+	}
+	size := gw.chunkSize - 12
+	count := (len(b) + size - 1) / size
+	if count > GELFMAXCHUNKS {
+		return nil, fmt.Errorf("message too big, %d chunks needed", count)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(b) {
+			end = len(b)
+		}
+		chunk := make([]byte, 0, 12+end-i*size)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, b[i*size:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// Write message to Graylog.
+func (gw *SGelfWriter) Write(msg SLogMsg) error {
+	prDeb("gelf.go - Write", "MSG: ", msg)
+	if !gw.mainLogger.MustWrite("gelf", msg) {
+		return nil
+	}
+	b, err := gw.encode(msg)
+	if err != nil {
+		return err
+	}
+	var packets [][]byte
+	if gw.network == "tcp" {
+		// TCP messages are null byte delimited and not compressed
+		packets = [][]byte{append(b, 0)}
+	} else if packets, err = gw.chunks(gw.compress(b)); err != nil {
+		return err
+	}
+	gw.Lock()
+	defer gw.Unlock()
+	// retry once reconnecting when the connection is lost
+	for i := 0; i < 2; i++ {
+		if gw.conn == nil {
+			if gw.conn, err = net.Dial(gw.network, gw.addr); err != nil {
+				gw.conn = nil
+				return err
+			}
+		}
+		for _, p := range packets {
+			if _, err = gw.conn.Write(p); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		gw.conn.Close()
+		gw.conn = nil
+	}
+	return err
+}
+
+// Destroy close the connection.
+func (gw *SGelfWriter) Destroy() {
+	gw.Lock()
+	defer gw.Unlock()
+	if gw.conn != nil {
+		gw.conn.Close()
+		gw.conn = nil
+	}
+}
+
+// implementing method. empty.
+func (gw *SGelfWriter) Flush() {
+
+}
+
+func init() {
+	CreateWriter("gelf", NewGelfWriter)
+}
@@ -0,0 +1,135 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Read a GELF UDP message reassembling the chunks
+func readGelfUDP(t *testing.T, conn net.PacketConn) []byte {
+	buf := make([]byte, 65536)
+	var chunks [][]byte
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("gelf read error. ERR: %s", err)
+		}
+		p := append([]byte(nil), buf[:n]...)
+		if len(p) < 2 || p[0] != 0x1e || p[1] != 0x0f {
+			return p
+		}
+		seq, count := p[10], p[11]
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		if len(chunks) != int(count) || int(seq) >= len(chunks) {
+			t.Fatalf("invalid chunk header %v", p[:12])
+		}
+		chunks[seq] = p[12:]
+		complete := true
+		for _, c := range chunks {
+			complete = complete && c != nil
+		}
+		if complete {
+			return bytes.Join(chunks, nil)
+		}
+	}
+}
+
+func TestGelfUDPChunked(t *testing.T) {
+	name := "TestGelfUDPChunked"
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: can not listen. ERR: %s", name, err)
+	}
+	defer conn.Close()
+	config := fmt.Sprintf(`{"gelf":{"sev":5, "dlev":2, "addr":%q, "host":"host", "chunksize":64, "fields":{"env":"test"}}}`, conn.LocalAddr().String())
+	l := NewLogDeb(10, config)
+	l.SetSessionId(name)
+	long := strings.Repeat("gelf chunked message ", 50)
+	l.Debl(tFncName(name), long, DLE)
+	l.Destroy()
+	zr, err := gzip.NewReader(bytes.NewReader(readGelfUDP(t, conn)))
+	if err != nil {
+		t.Fatalf("%s: invalid gzip message. ERR: %s", name, err)
+	}
+	b, _ := ioutil.ReadAll(zr)
+	var gm map[string]interface{}
+	if err := json.Unmarshal(b, &gm); err != nil {
+		t.Fatalf("%s: invalid JSON %q. ERR: %s", name, b, err)
+	}
+	expect := map[string]interface{}{"version": "1.1", "host": "host", "short_message": long, "level": float64(7),
+		"_fnc": name, "_sev": "debug", "_debug_level": float64(DLE), "_session_id": name, "_env": "test"}
+	for k, v := range expect {
+		if gm[k] != v {
+			t.Errorf("%s: field %s EXPECT => %v GOT => %v", name, k, v, gm[k])
+		}
+	}
+}
+
+func TestGelfTCP(t *testing.T) {
+	name := "TestGelfTCP"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: can not listen. ERR: %s", name, err)
+	}
+	defer ln.Close()
+	msgs := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			m, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			msgs <- strings.TrimSuffix(m, "\x00")
+		}
+	}()
+	config := fmt.Sprintf(`{"gelf":{"sev":2, "network":"tcp", "addr":%q}}`, ln.Addr().String())
+	l := NewLogDeb(10, config)
+	l.Err(tFncName(name), "gelf error 1")
+	l.Fatal(tFncName(name), "gelf fatal 2")
+	l.Destroy()
+	for i, level := range []float64{3, 2} {
+		select {
+		case m := <-msgs:
+			var gm map[string]interface{}
+			if err := json.Unmarshal([]byte(m), &gm); err != nil {
+				t.Fatalf("%s: invalid JSON %q. ERR: %s", name, m, err)
+			}
+			if gm["level"] != level || gm["short_message"] != fmt.Sprintf("gelf %s %d", gm["_sev"], i+1) {
+				t.Errorf("%s: unexpected message %q", name, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: message %d not received", name, i+1)
+		}
+	}
+}
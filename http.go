@@ -0,0 +1,340 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP writer payload formats
+const (
+	HTTPJSON   = "json"   // JSON array of messages
+	HTTPNDJSON = "ndjson" // one JSON message per line
+	HTTPLOKI   = "loki"   // Loki push API
+	HTTPESBULK = "esbulk" // Elasticsearch bulk API
+)
+
+// HTTP writer defaults
+const (
+	HTTPDEFBATCHSIZE  = 100
+	HTTPDEFBATCHAGE   = 1000 // milliseconds
+	HTTPDEFRETRIES    = 3
+	HTTPDEFRETRYDELAY = 500  // milliseconds
+	HTTPDEFTIMEOUT    = 5000 // milliseconds
+	HTTPDEFESINDEX    = "logdeb"
+)
+
+// SHttpWriter batches messages and posts them to a log collector
+type SHttpWriter struct {
+	sync.Mutex
+	mainLogger *SLogger
+	client     *http.Client
+	url        string
+	headers    map[string]string
+	format     string
+	gzip       bool
+	batchSize  int
+	batchAge   time.Duration
+	retries    int
+	retryDelay time.Duration
+	esIndex    string            // index used by the Elasticsearch bulk format
+	lokiLabels map[string]string // stream labels used by the Loki format
+	batch      []SLogMsg
+	batchTime  time.Time // time of the first message in batch
+	done       chan bool // stop the batch age timer
+	wg         sync.WaitGroup
+}
+
+// NewHttpWriter: create SHttpWriter returning as ILogWriter.
+func NewHttpWriter() ILogWriter {
+	hw := new(SHttpWriter)
+	hw.client = &http.Client{Timeout: HTTPDEFTIMEOUT * time.Millisecond}
+	hw.headers = make(map[string]string)
+	hw.format = HTTPJSON
+	hw.batchSize = HTTPDEFBATCHSIZE
+	hw.batchAge = HTTPDEFBATCHAGE * time.Millisecond
+	hw.retries = HTTPDEFRETRIES
+	hw.retryDelay = HTTPDEFRETRYDELAY * time.Millisecond
+	hw.esIndex = HTTPDEFESINDEX
+	hw.lokiLabels = map[string]string{"app": cPckName}
+	return hw
+}
+
+// getConfig: extract configuration
+func (hw *SHttpWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["url"]; t {
+			hw.url = v.(string)
+		}
+		if v, t := confout["headers"]; t {
+			for hk, hv := range v.(map[string]interface{}) {
+				hw.headers[hk] = hv.(string)
+			}
+		}
+		if v, t := confout["format"]; t {
+			hw.format = strings.ToLower(v.(string))
+		}
+		if v, t := confout["gzip"]; t {
+			hw.gzip = v.(bool)
+		}
+		if v, t := confout["batchsize"]; t {
+			hw.batchSize = int(v.(float64))
+		}
+		if v, t := confout["batchage"]; t {
+			hw.batchAge = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["retries"]; t {
+			hw.retries = int(v.(float64))
+		}
+		if v, t := confout["retrydelay"]; t {
+			hw.retryDelay = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["timeout"]; t {
+			hw.client.Timeout = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["index"]; t {
+			hw.esIndex = v.(string)
+		}
+		if v, t := confout["labels"]; t {
+			hw.lokiLabels = make(map[string]string)
+			for lk, lv := range v.(map[string]interface{}) {
+				hw.lokiLabels[lk] = lv.(string)
+			}
+		}
+	}
+	switch hw.format {
+	case HTTPJSON, HTTPNDJSON, HTTPLOKI, HTTPESBULK:
+	default:
+		return fmt.Errorf("unknown http format %q", hw.format)
+	}
+	if hw.batchSize < 1 {
+		hw.batchSize = 1
+	}
+	return nil
+}
+
+// Init http logger and start the batch age timer.
+func (hw *SHttpWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	hw.mainLogger = logger
+	if err := hw.getConfig(config); err != nil {
+		return err
+	}
+	if len(hw.url) == 0 {
+		return errors.New("url not configured")
+	}
+	if hw.batchAge > 0 {
+		hw.done = make(chan bool)
+		hw.wg.Add(1)
+		go hw.timer()
+	}
+	return nil
+}
+
+// timer: send the batch when it is older than batchAge
+func (hw *SHttpWriter) timer() {
+	defer hw.wg.Done()
+	ticker := time.NewTicker(hw.batchAge / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hw.done:
+			return
+		case <-ticker.C:
+			hw.Lock()
+			if len(hw.batch) > 0 && time.Since(hw.batchTime) >= hw.batchAge {
+				hw.send()
+			}
+			hw.Unlock()
+		}
+	}
+}
+
+// payload: build the request body for the batch
+func (hw *SHttpWriter) payload(batch []SLogMsg) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch hw.format {
+	case HTTPJSON:
+		msgs := make([]json.RawMessage, 0, len(batch))
+		for _, msg := range batch {
+			b, err := msgJSON(msg)
+			if err != nil {
+				return nil, "", err
+			}
+			msgs = append(msgs, b)
+		}
+		b, err := json.Marshal(msgs)
+		return b, "application/json", err
+	case HTTPLOKI:
+		// one stream for each severity
+		type sLokiStream struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		}
+		streams := make(map[tSeverity]*sLokiStream)
+		var order []tSeverity
+		for _, msg := range batch {
+			s, ok := streams[msg.sev]
+			if !ok {
+				s = &sLokiStream{Stream: map[string]string{"level": msg.sev.name()}}
+				for lk, lv := range hw.lokiLabels {
+					s.Stream[lk] = lv
+				}
+				streams[msg.sev] = s
+				order = append(order, msg.sev)
+			}
+			b, err := msgJSON(msg)
+			if err != nil {
+				return nil, "", err
+			}
+			s.Values = append(s.Values, [2]string{strconv.FormatInt(msg.ts.UnixNano(), 10), string(b)})
+		}
+		push := struct {
+			Streams []*sLokiStream `json:"streams"`
+		}{}
+		for _, sev := range order {
+			push.Streams = append(push.Streams, streams[sev])
+		}
+		b, err := json.Marshal(push)
+		return b, "application/json", err
+	default:
+		// ndjson and Elasticsearch bulk
+		action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": hw.esIndex}})
+		for _, msg := range batch {
+			b, err := msgJSON(msg)
+			if err != nil {
+				return nil, "", err
+			}
+			if hw.format == HTTPESBULK {
+				buf.Write(action)
+				buf.WriteByte('\n')
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+}
+
+// post: post the body, returns true when the request can be retried
+func (hw *SHttpWriter) post(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequest("POST", hw.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if hw.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for hk, hv := range hw.headers {
+		req.Header.Set(hk, hv)
+	}
+	resp, err := hw.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("http status %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("http status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// send: post the batch. When all the tries fail the batch is counted as
+// dropped and given to the fallback writer
+func (hw *SHttpWriter) send() {
+	if len(hw.batch) == 0 {
+		return
+	}
+	batch := hw.batch
+	hw.batch = nil
+	if err := hw.sendBatch(batch); err != nil {
+		hw.mainLogger.writeFailed("http", err, batch...)
+	}
+}
+
+// sendBatch: post the batch, retrying on network errors and 5xx responses
+func (hw *SHttpWriter) sendBatch(batch []SLogMsg) error {
+	body, contentType, err := hw.payload(batch)
+	if err != nil {
+		return err
+	}
+	if hw.gzip {
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(body)
+		zw.Close()
+		body = zbuf.Bytes()
+	}
+	for i := 0; ; i++ {
+		retry, err := hw.post(body, contentType)
+		if err == nil || !retry || i >= hw.retries {
+			return err
+		}
+		prDeb("http.go - send", "Retry. Err: ", err)
+		time.Sleep(hw.retryDelay << uint(i))
+	}
+}
+
+// Write message in the batch, the batch is sent when full.
+func (hw *SHttpWriter) Write(msg SLogMsg) error {
+	prDeb("http.go - Write", "MSG: ", msg)
+	if !hw.mainLogger.MustWrite("http", msg) {
+		return nil
+	}
+	hw.Lock()
+	defer hw.Unlock()
+	if len(hw.batch) == 0 {
+		hw.batchTime = time.Now()
+	}
+	hw.batch = append(hw.batch, msg)
+	if len(hw.batch) >= hw.batchSize {
+		hw.send()
+	}
+	return nil
+}
+
+// Destroy stop the timer and send the batch.
+func (hw *SHttpWriter) Destroy() {
+	if hw.done != nil {
+		close(hw.done)
+		hw.wg.Wait()
+		hw.done = nil
+	}
+	hw.Flush()
+}
+
+// Flush send the batch.
+func (hw *SHttpWriter) Flush() {
+	hw.Lock()
+	defer hw.Unlock()
+	hw.send()
+}
+
+func init() {
+	CreateWriter("http", NewHttpWriter)
+}
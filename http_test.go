@@ -0,0 +1,206 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sHttpCollector is a stand-in log collector recording the requests
+type sHttpCollector struct {
+	sync.Mutex
+	bodies  []string
+	headers []http.Header
+	fails   int // number of requests answered with status 500
+}
+
+func (c *sHttpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	if c.fails > 0 {
+		c.fails--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var rd io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		rd, _ = gzip.NewReader(r.Body)
+	}
+	b, _ := ioutil.ReadAll(rd)
+	c.bodies = append(c.bodies, string(b))
+	c.headers = append(c.headers, r.Header)
+}
+
+func TestHttpJSON(t *testing.T) {
+	name := "TestHttpJSON"
+	c := new(sHttpCollector)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	config := fmt.Sprintf(`{"http":{"sev":5, "url":%q, "batchsize":2, "batchage":0, "headers":{"X-Token":"secret"}}}`, srv.URL)
+	l := NewLogDeb(10, config)
+	l.Deb(tFncName(name), "test http 1")
+	l.Deb(tFncName(name), "test http 2")
+	l.Err(tFncName(name), "test http 3")
+	l.Destroy()
+	if len(c.bodies) != 2 {
+		t.Fatalf("%s: expected 2 batches, got %d: %v", name, len(c.bodies), c.bodies)
+	}
+	var batch []sJSONMsg
+	if err := json.Unmarshal([]byte(c.bodies[0]), &batch); err != nil || len(batch) != 2 {
+		t.Fatalf("%s: invalid batch %q. ERR: %v", name, c.bodies[0], err)
+	}
+	if batch[1].Msg != "test http 2" || batch[1].Severity != "debug" {
+		t.Errorf("%s: unexpected message %v", name, batch[1])
+	}
+	if c.headers[0].Get("X-Token") != "secret" || c.headers[0].Get("Content-Type") != "application/json" {
+		t.Errorf("%s: unexpected headers %v", name, c.headers[0])
+	}
+}
+
+func TestHttpNDJSONGzipRetry(t *testing.T) {
+	name := "TestHttpNDJSONGzipRetry"
+	c := &sHttpCollector{fails: 2}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	config := fmt.Sprintf(`{"http":{"sev":5, "url":%q, "format":"ndjson", "gzip":true, "retrydelay":1}}`, srv.URL)
+	l := NewLogDeb(10, config)
+	l.Info(tFncName(name), "test http 1")
+	l.Warn(tFncName(name), "test http 2")
+	l.Destroy()
+	if len(c.bodies) != 1 {
+		t.Fatalf("%s: expected 1 batch, got %d: %v", name, len(c.bodies), c.bodies)
+	}
+	lines := strings.Split(strings.TrimSpace(c.bodies[0]), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"msg":"test http 2"`) {
+		t.Errorf("%s: unexpected body %q", name, c.bodies[0])
+	}
+}
+
+func TestHttpESBulk(t *testing.T) {
+	name := "TestHttpESBulk"
+	c := new(sHttpCollector)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	config := fmt.Sprintf(`{"http":{"sev":5, "url":%q, "format":"esbulk", "index":"applog"}}`, srv.URL)
+	l := NewLogDeb(10, config)
+	l.Err(tFncName(name), "test http es")
+	l.Destroy()
+	lines := strings.Split(strings.TrimSpace(strings.Join(c.bodies, "")), "\n")
+	if len(lines) != 2 || lines[0] != `{"index":{"_index":"applog"}}` || !strings.Contains(lines[1], `"sev":"error"`) {
+		t.Errorf("%s: unexpected body %q", name, c.bodies)
+	}
+}
+
+func TestHttpLoki(t *testing.T) {
+	name := "TestHttpLoki"
+	c := new(sHttpCollector)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	config := fmt.Sprintf(`{"http":{"sev":5, "url":%q, "format":"loki", "labels":{"job":"test"}}}`, srv.URL)
+	l := NewLogDeb(10, config)
+	l.Err(tFncName(name), "test http loki error")
+	l.Deb(tFncName(name), "test http loki debug")
+	l.Err(tFncName(name), "test http loki error 2")
+	l.Destroy()
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if len(c.bodies) != 1 {
+		t.Fatalf("%s: expected 1 batch, got %d: %v", name, len(c.bodies), c.bodies)
+	}
+	if err := json.Unmarshal([]byte(c.bodies[0]), &push); err != nil {
+		t.Fatalf("%s: invalid body %q. ERR: %s", name, c.bodies[0], err)
+	}
+	if len(push.Streams) != 2 || push.Streams[0].Stream["level"] != "error" || push.Streams[0].Stream["job"] != "test" || len(push.Streams[0].Values) != 2 {
+		t.Errorf("%s: unexpected streams %q", name, c.bodies[0])
+	}
+}
+
+func TestHttpBatchAge(t *testing.T) {
+	name := "TestHttpBatchAge"
+	c := new(sHttpCollector)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	config := fmt.Sprintf(`{"http":{"sev":5, "url":%q, "batchage":10}}`, srv.URL)
+	l := NewLogDeb(10, config)
+	defer l.Destroy()
+	l.Deb(tFncName(name), "test http age")
+	for i := 0; i < 100; i++ {
+		c.Lock()
+		n := len(c.bodies)
+		c.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("%s: batch not sent after batch age", name)
+}
+
+func TestHttpFailure(t *testing.T) {
+	name := "TestHttpFailure"
+	fnc := tFncName(name)
+	c := &sHttpCollector{fails: 100}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	config := fmt.Sprintf(`{"main":{"fallback":"stderr"}, "http":{"flags":0, "sev":5, "url":%q, "batchsize":2, "batchage":10, "retries":0}}`, srv.URL)
+	var lock sync.Mutex
+	var errs []string
+	preTestConsole()
+	l := NewLogDeb(10, config)
+	l.SetErrorHandler(func(writer string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		errs = append(errs, writer+": "+err.Error())
+	})
+	// the first batch is sent by Write, the second by the batch age timer
+	l.Info(fnc, "test http failure 1")
+	l.Info(fnc, "test http failure 2")
+	l.Info(fnc, "test http failure 3")
+	for i := 0; i < 100 && l.DroppedMessages()["http"] < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.Destroy()
+	postTestConsole()
+	<-outC
+	out := <-errC
+	// the whole failed batches are written on stderr
+	for i := 1; i <= 3; i++ {
+		if !strings.Contains(out, fmt.Sprintf("test http failure %d", i)) {
+			t.Errorf("%s: message %d not written on stderr %q", name, i, out)
+		}
+	}
+	if d := l.DroppedMessages()["http"]; d != 3 {
+		t.Errorf("%s: expected 3 dropped messages, got %d", name, d)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "http: http status 500") {
+		t.Errorf("%s: unexpected errors %q", name, errs)
+	}
+}
@@ -16,10 +16,15 @@ package logdeb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -63,10 +68,13 @@ type sWriteRules struct {
 
 // Log message details
 type SLogMsg struct {
-	fnc    tFncName
-	msg    string
-	sev    tSeverity
-	debLev tDebLevel
+	fnc       tFncName
+	msg       string
+	sev       tSeverity
+	debLev    tDebLevel
+	ts        time.Time       // time of the log call
+	sessionId string          // log session Id at the log call
+	flush     *sync.WaitGroup // flush marker, done by each writer when reached
 }
 
 // Writer interface
@@ -77,28 +85,93 @@ type ILogWriter interface {
 	Flush()
 }
 
+// sFailedWriter replaces a writer failed to initialize: every message
+// fails with the initialization error and is written by the fallback
+type sFailedWriter struct {
+	err error
+}
+
+func (fw sFailedWriter) Init(logger *SLogger, config map[string]interface{}) error { return fw.err }
+func (fw sFailedWriter) Write(msg SLogMsg) error                                   { return fw.err }
+func (fw sFailedWriter) Destroy()                                                  {}
+func (fw sFailedWriter) Flush()                                                    {}
+
+// Writer that can reopen its output, e.g. after an external log rotation
+type IReopener interface {
+	Reopen() error
+}
+
 // Writer + write rules
 type SLogWriter struct {
-	writer     ILogWriter
-	writeRules sWriteRules
+	writer  ILogWriter
+	queue   *sMsgQueue // writer message queue
+	dropped *uint64    // messages not written because of a writer error or a full queue
 }
 
 type tLogWriter func() ILogWriter
 
+// Error handler, called with the writer name when a writer fails
+type tErrorHandler func(writer string, err error)
+
+// Main configuration
+type sMainConfig struct {
+	Severity        tSeverity `json:"sev"`             // main rule severity
+	DebugLevel      tDebLevel `json:"dlev"`            // main rule debug level
+	UseFncRules     bool      `json:"usefncrules"`     // use the write function rules
+	Fallback        string    `json:"fallback"`        // writer used when a writer fails, stderr or a writer name
+	Overflow        string    `json:"overflow"`        // overflow policy of the message queue
+	OverflowTimeout float64   `json:"overflowtimeout"` // wait time in milliseconds of timeout overflow policy
+	DropSev         tSeverity `json:"dropsev"`         // messages less severe are dropped by dropbelow overflow policy
+	Async           *bool     `json:"async"`           // write through the message queue, default true
+	SyncFatal       bool      `json:"syncfatal"`       // write fatal messages directly also in async mode
+	OnFatal         string    `json:"onfatal"`         // after a fatal message: exit, panic or nothing
+	ExitCode        *int      `json:"exitcode"`        // exit code of onfatal exit, default 1
+}
+
+// Fatal message actions
+const (
+	ONFATALEXIT  = "exit"  // exit the process
+	ONFATALPANIC = "panic" // panic
+)
+
+// Used by Fatal to exit the process, replaced by tests
+var osExit = os.Exit
+
 var logWriters = make(map[string]tLogWriter)
 
+// sRules is a snapshot of the write rules. It is never modified once
+// stored in the logger, a rule change stores a new snapshot
+type sRules struct {
+	main        sBaseRule              // Log Severity & DebugLevel
+	useFncRules bool                   // Define if write function rules must be used
+	writers     map[string]sWriteRules // Write rules of each writer
+	maxSeverity tSeverity              // Maximum severity defined for writers
+	maxDebLev   tDebLevel              // Maximum debug level used by writers. Used to discard message immediately
+}
+
 // SLogger is the basic struct of deblog
 type SLogger struct {
 	lock        sync.Mutex            // ensures atomic writes; protects the following fields
 	wg          sync.WaitGroup        // wait until all channels are drained
-	msgChan     chan *SLogMsg         // Channels that will dispatch the log messages
+	queue       *sMsgQueue            // Queue that will dispatch the log messages
 	writers     map[string]SLogWriter // Log writers
 	buf         bytes.Buffer          // for accumulating text to write
-	sBaseRule                         // Log Severity & DebugLevel
-	maxSeverity tSeverity             // Maximum severity defined for writers
-	maxDebLev   tDebLevel             // Maximum debug level used by writers. Used to discard message immediately
-	UseFncRules bool                  // Define if write function rules must be used
-	sessionId   string                // Log session Id
+	rules       atomic.Value          // *sRules, current write rules
+	rulesLock   sync.Mutex            // serializes the rule changes
+	sessionId   atomic.Value          // string, Log session Id
+	errHandler  tErrorHandler         // Called when a writer fails
+	fallback    string                // Writer used when a writer fails, stderr or a writer name
+	stderr      *log.Logger           // stderr fallback
+	sync        bool                  // write directly to writers instead of through the message queue
+	syncFatal   bool                  // write fatal messages directly
+	onFatal     string                // action after a fatal message
+	exitCode    int                   // exit code of onfatal exit
+	fatalHook   func()                // called after a fatal message, before onfatal action
+	syncLock    sync.Mutex            // serializes the direct writes
+	closeLock   sync.RWMutex          // protects closed against the writes to the queue
+	closed      bool                  // the logger has been destroyed
+	destroyOnce sync.Once             // destroys the logger only once
+	destroyed   chan struct{}         // closed when all writers have been destroyed
 }
 
 // Get timestamp
@@ -124,6 +197,67 @@ func getConfig(config map[string]interface{}) (confout map[string]interface{}) {
 	return
 }
 
+// writeMsg: format the message and write it using the logger.
+// When flags are set the message is preceded by the separator
+func writeMsg(l *log.Logger, flags int, sep string, msg SLogMsg) error {
+	fnc := fmt.Sprintf("%v[%s]", msg.fnc, msg.sev)
+	if flags > 0 {
+		return l.Output(2, fmt.Sprintln(sep, fnc, sep, msg.msg))
+	}
+	return l.Output(2, fmt.Sprintln(fnc, sep, msg.msg))
+}
+
+// sJSONMsg is the JSON representation of a log message
+type sJSONMsg struct {
+	Time       string    `json:"time"`
+	Severity   string    `json:"sev"`
+	DebugLevel tDebLevel `json:"dlev,omitempty"`
+	Fnc        tFncName  `json:"fnc"`
+	SessionId  string    `json:"session,omitempty"`
+	Msg        string    `json:"msg"`
+}
+
+// msgJSON: encode the message in JSON, one object without line terminator
+func msgJSON(msg SLogMsg) ([]byte, error) {
+	return json.Marshal(sJSONMsg{
+		Time:       msg.ts.Format(time.RFC3339Nano),
+		Severity:   msg.sev.name(),
+		DebugLevel: msg.debLev,
+		Fnc:        msg.fnc,
+		SessionId:  msg.sessionId,
+		Msg:        msg.msg,
+	})
+}
+
+// sBackoff computes the retry time with exponential backoff
+type sBackoff struct {
+	min  time.Duration
+	max  time.Duration
+	cur  time.Duration // current backoff
+	next time.Time     // retry not before
+}
+
+// waiting: true when the retry time is not yet reached
+func (b *sBackoff) waiting() bool {
+	return time.Now().Before(b.next)
+}
+
+// fail: double the backoff and compute the next retry time
+func (b *sBackoff) fail() {
+	if b.cur == 0 {
+		b.cur = b.min
+	} else if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	b.next = time.Now().Add(b.cur)
+}
+
+// reset: retry immediately on next failure
+func (b *sBackoff) reset() {
+	b.cur = 0
+	b.next = time.Time{}
+}
+
 // Extract write rules from json config
 func getWriteRules(config map[string]interface{}) sWriteRules {
 	prDeb("getWriteRules", "config:", config)
@@ -152,13 +286,12 @@ func getWriteRules(config map[string]interface{}) sWriteRules {
 func NewLogDeb(bufferSize int64, config string) *SLogger {
 	const cFncName = cPckName + ".NewLogDeb"
 	l := new(SLogger)
-	l.SetSeverity(SEVERROR)
-	l.SetDebugLevel(DLB)
+	rules := &sRules{main: sBaseRule{SEVERROR, DLB}, writers: make(map[string]sWriteRules)}
 	l.SetSessionId("GEN" + GetTsStr())
-	l.msgChan = make(chan *SLogMsg, bufferSize)
+	l.queue = newMsgQueue(bufferSize)
 	l.writers = make(map[string]SLogWriter)
-	l.wg.Add(1)
-	go l.StartWriter()
+	l.stderr = log.New(os.Stderr, "", log.Ldate|log.Ltime)
+	l.destroyed = make(chan struct{})
 
 	// Read writers and their configuration from config
 	var writersConf map[string]json.RawMessage
@@ -171,28 +304,51 @@ func NewLogDeb(bufferSize int64, config string) *SLogger {
 	defer l.lock.Unlock()
 	for wr, c := range writersConf {
 		if wr == "main" {
-			json.Unmarshal(c, &l)
-			if l.Severity == 0 {
-				l.SetSeverity(SEVERROR)
-			} else {
-				l.setMaxSeverity(l.Severity)
+			var mc sMainConfig
+			json.Unmarshal(c, &mc)
+			rules.useFncRules = mc.UseFncRules
+			l.fallback = mc.Fallback
+			l.sync = mc.Async != nil && !*mc.Async
+			l.syncFatal = mc.SyncFatal
+			l.onFatal = strings.ToLower(mc.OnFatal)
+			if l.onFatal != "" && l.onFatal != ONFATALEXIT && l.onFatal != ONFATALPANIC {
+				panic(fmt.Sprintf("logdeb: unknown onfatal action %q", mc.OnFatal))
 			}
-			if l.DebugLevel == 0 {
-				l.SetDebugLevel(DLB)
-			} else {
-				l.setMaxDebugLevel(l.DebugLevel)
+			l.exitCode = 1
+			if mc.ExitCode != nil {
+				l.exitCode = *mc.ExitCode
+			}
+			if err := l.queue.setOverflow(strings.ToLower(mc.Overflow), time.Duration(mc.OverflowTimeout)*time.Millisecond, mc.DropSev); err != nil {
+				panic(fmt.Sprintf("logdeb: %s", err))
+			}
+			if mc.Severity != 0 {
+				rules.main.Severity = mc.Severity
+			}
+			if mc.DebugLevel != 0 {
+				rules.main.DebugLevel = mc.DebugLevel
 			}
-			prDeb(cFncName, *l)
+			if err := checkMainRule(rules.main); err != nil {
+				panic(err.Error())
+			}
+			prDeb(cFncName, mc)
 		} else {
 			if logWriter, ok := logWriters[wr]; ok {
 				lw := logWriter()
 				var ci interface{}
 				json.Unmarshal(c, &ci)
 				cm := ci.(map[string]interface{})
-				lw.Init(l, cm)
-				l.writers[wr] = SLogWriter{writer: lw, writeRules: getWriteRules(cm)}
-				l.setMaxSeverity(l.writers[wr].writeRules.Severity)
-				l.setMaxDebugLevel(l.writers[wr].writeRules.DebugLevel)
+				if err := lw.Init(l, cm); err != nil {
+					lw = sFailedWriter{fmt.Errorf("logdeb: error initializing writer %q. ERR: %s", wr, err)}
+				}
+				q := newMsgQueue(bufferSize)
+				if err := q.getConfig(cm); err != nil {
+					panic(fmt.Sprintf("logdeb: writer %q. ERR: %s", wr, err))
+				}
+				dropped := new(uint64)
+				// the messages dropped by the writer queue, new or evicted, are dropped by the writer
+				q.onDrop = func() { atomic.AddUint64(dropped, 1) }
+				l.writers[wr] = SLogWriter{writer: lw, queue: q, dropped: dropped}
+				rules.writers[wr] = getWriteRules(cm)
 			} else {
 				panic(fmt.Sprintf("logdeb: unknown writer %q (forgotten Register?)", wr))
 			}
@@ -201,6 +357,18 @@ func NewLogDeb(bufferSize int64, config string) *SLogger {
 	if len(l.writers) == 0 {
 		panic("No writer configured")
 	}
+	if _, ok := l.writers[l.fallback]; !ok && l.fallback != "" && l.fallback != "stderr" {
+		panic(fmt.Sprintf("logdeb: unknown fallback writer %q", l.fallback))
+	}
+	rules.setMax()
+	l.rules.Store(rules)
+	// Start the writers when they are all configured
+	for name, lw := range l.writers {
+		l.wg.Add(1)
+		go l.runWriter(name, lw)
+	}
+	l.wg.Add(1)
+	go l.StartWriter()
 	return l
 }
 
@@ -216,35 +384,152 @@ func CreateWriter(name string, writer tLogWriter) {
 	logWriters[name] = writer
 }
 
+// Writer returns the configured writer with the given name, nil if it is not configured
+func (l *SLogger) Writer(name string) ILogWriter {
+	if lw, ok := l.writers[name]; ok {
+		return lw.writer
+	}
+	return nil
+}
+
+// DroppedBySeverity returns, for each severity, the number of messages
+// dropped by the overflow policy because the message queue was full.
+// The messages dropped by the writer queues are in WriterDroppedBySeverity
+func (l *SLogger) DroppedBySeverity() map[tSeverity]uint64 {
+	return l.queue.droppedBySeverity()
+}
+
+// WriterDroppedBySeverity returns, for each writer and severity, the number
+// of messages dropped by the writer overflow policy because the writer
+// queue was full
+func (l *SLogger) WriterDroppedBySeverity() map[string]map[tSeverity]uint64 {
+	dropped := make(map[string]map[tSeverity]uint64, len(l.writers))
+	for name, lw := range l.writers {
+		dropped[name] = lw.queue.droppedBySeverity()
+	}
+	return dropped
+}
+
+// SetErrorHandler set the function called when a writer fails to write a message
+func (l *SLogger) SetErrorHandler(handler func(writer string, err error)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.errHandler = handler
+}
+
+// DroppedMessages returns, for each writer, the number of messages not
+// written because of a writer error or because the writer queue was full
+func (l *SLogger) DroppedMessages() map[string]uint64 {
+	dropped := make(map[string]uint64, len(l.writers))
+	for name, lw := range l.writers {
+		dropped[name] = atomic.LoadUint64(lw.dropped)
+	}
+	return dropped
+}
+
+// ReportError calls the error handler. Writers use it to report errors
+// not related to a message
+func (l *SLogger) ReportError(writer string, err error) {
+	l.lock.Lock()
+	handler := l.errHandler
+	l.lock.Unlock()
+	if handler != nil {
+		handler(writer, err)
+	}
+}
+
+// writeFailed: count the dropped messages, call the error handler and write
+// the messages with the fallback writer. Writers use it for the messages
+// lost after Write returned, e.g. a batch sent by a timer
+func (l *SLogger) writeFailed(name string, err error, msgs ...SLogMsg) {
+	prDeb("writeFailed", "writer:", name, "err:", err)
+	if lw, ok := l.writers[name]; ok {
+		atomic.AddUint64(lw.dropped, uint64(len(msgs)))
+	}
+	l.ReportError(name, err)
+	for _, msg := range msgs {
+		if l.fallback == "stderr" {
+			writeMsg(l.stderr, log.Ldate|log.Ltime, CONSSEP, msg)
+		} else if fw, ok := l.writers[l.fallback]; ok && l.fallback != name {
+			fw.writer.Write(msg)
+		}
+	}
+}
+
+// SessionId returns the log session unique identification
+func (l *SLogger) SessionId() string {
+	return l.sessionId.Load().(string)
+}
+
 // SetSessionId set the log session unique identification
 func (l *SLogger) SetSessionId(sessionId string) {
-	l.sessionId = sessionId
+	l.sessionId.Store(sessionId)
 }
 
-func (l *SLogger) setMaxSeverity(sev tSeverity) {
-	prDeb("setMaxSeverity", "sev:", sev, "maxSev:", l.maxSeverity)
-	if sev > l.maxSeverity {
-		l.maxSeverity = sev
+// getRules: current write rules snapshot
+func (l *SLogger) getRules() *sRules {
+	return l.rules.Load().(*sRules)
+}
+
+// updateRules: apply the change to a copy of the current rules, recompute
+// the maximum values and store the copy. The writer rules are shared,
+// change must replace them. The rules are not stored when change fails
+func (l *SLogger) updateRules(change func(r *sRules) error) error {
+	l.rulesLock.Lock()
+	defer l.rulesLock.Unlock()
+	r := *l.getRules()
+	r.writers = make(map[string]sWriteRules, len(r.writers))
+	for name, wr := range l.getRules().writers {
+		r.writers[name] = wr
 	}
+	if err := change(&r); err != nil {
+		return err
+	}
+	r.setMax()
+	l.rules.Store(&r)
+	return nil
 }
 
-func (l *SLogger) SetSeverity(sev tSeverity) {
-	l.Severity = sev
-	l.setMaxSeverity(sev)
+// Severity returns the main rule severity
+func (l *SLogger) Severity() tSeverity {
+	return l.getRules().main.Severity
 }
 
-func (l *SLogger) setMaxDebugLevel(debLev tDebLevel) {
-	prDeb("setMaxDebugLevel", "debLev:", debLev, "maxDebLev:", l.maxDebLev)
-	if debLev > l.maxDebLev {
-		l.maxDebLev = debLev
-	}
-	prDeb("setMaxDebugLevel", "debLev:", debLev, "maxDebLev:", l.maxDebLev)
+// SetSeverity set the main rule severity, returns an error when the
+// severity is not valid
+func (l *SLogger) SetSeverity(sev tSeverity) error {
+	return l.updateRules(func(r *sRules) error {
+		r.main.Severity = sev
+		return checkMainRule(r.main)
+	})
+}
+
+// DebugLevel returns the main rule debug level
+func (l *SLogger) DebugLevel() tDebLevel {
+	return l.getRules().main.DebugLevel
+}
+
+// SetDebugLevel set the main rule debug level, returns an error when the
+// debug level is not valid
+func (l *SLogger) SetDebugLevel(debLev tDebLevel) error {
+	prDeb("SetDebugLevel", "debLev:", debLev)
+	return l.updateRules(func(r *sRules) error {
+		r.main.DebugLevel = debLev
+		return checkMainRule(r.main)
+	})
 }
 
-func (l *SLogger) SetDebugLevel(debLev tDebLevel) {
-	l.DebugLevel = debLev
-	l.setMaxDebugLevel(debLev)
-	prDeb("SetDebugLevel", "debLev:", debLev, "maxDebLev:", l.maxDebLev)
+// UseFncRules returns true when the write function rules are used
+func (l *SLogger) UseFncRules() bool {
+	return l.getRules().useFncRules
+}
+
+// SetUseFncRules enable or disable the write function rules
+func (l *SLogger) SetUseFncRules(use bool) {
+	l.updateRules(func(r *sRules) error {
+		r.useFncRules = use
+		return nil
+	})
 }
 
 // extract: extract base rule value from config
@@ -281,51 +566,159 @@ func (r sBaseRule) eval(msg SLogMsg, parentRule sBaseRule) bool {
 
 func (l *SLogger) MustWrite(writerName string, msg SLogMsg) bool {
 	prDeb("MustWrite")
-	if l.UseFncRules && len(l.writers[writerName].writeRules.FncRules) > 0 {
+	r := l.getRules()
+	wr := r.writers[writerName]
+	if r.useFncRules && len(wr.FncRules) > 0 {
 		// Search inside FncRules for function name matching
 		// or plartial matching
 		for {
 			if len(msg.fnc) == 0 {
 				return false
 			}
-			if rule, ok := l.writers[writerName].writeRules.FncRules[msg.fnc]; ok {
-				return rule.eval(msg, l.writers[writerName].writeRules.get(sBaseRule{l.Severity, l.DebugLevel}))
+			if rule, ok := wr.FncRules[msg.fnc]; ok {
+				return rule.eval(msg, wr.get(r.main))
 			}
 			msg.fnc = msg.fnc[:len(msg.fnc)-1]
 		}
 	} else {
-		prDeb("MustWrite", "BaseRule", l.writers[writerName].writeRules)
-		return l.writers[writerName].writeRules.eval(msg, sBaseRule{l.Severity, l.DebugLevel}) // l.evalRule(msg, sBaseRule())
+		prDeb("MustWrite", "BaseRule", wr)
+		return wr.eval(msg, r.main) // l.evalRule(msg, sBaseRule())
 	}
-	return false
 }
 
+// StartWriter dispatches the messages to the writer queues, applying
+// the writer overflow policies. Writer queues are closed when the
+// message queue is closed
 func (l *SLogger) StartWriter() {
 	prDeb("StartWriter", "run")
 	defer l.wg.Done()
-	for lm := range l.msgChan {
+	for lm := range l.queue.ch {
 		for _, lw := range l.writers {
 			prDeb("StartWriter", "lw:", lw, ":: lm:", *lm)
-			lw.writer.Write(*lm)
+			if lm.flush != nil {
+				lw.queue.ch <- lm
+				continue
+			}
+			lw.queue.push(lm)
+		}
+	}
+	for _, lw := range l.writers {
+		close(lw.queue.ch)
+	}
+}
+
+// runWriter: write the messages of the writer queue, each writer runs
+// in its own goroutine
+func (l *SLogger) runWriter(name string, lw SLogWriter) {
+	prDeb("runWriter", "run", name)
+	defer l.wg.Done()
+	for lm := range lw.queue.ch {
+		if lm.flush != nil {
+			lw.writer.Flush()
+			lm.flush.Done()
+			continue
+		}
+		if err := lw.writer.Write(*lm); err != nil {
+			l.writeFailed(name, err, *lm)
 		}
 	}
 }
 
 func (l *SLogger) logw(fnc tFncName, msg string, sev tSeverity, debLev tDebLevel) error {
-	const cFncName = cPckName + ".logw"
-	prDeb(cFncName, "sev:", sev, ":: maxSeverity:", l.maxSeverity, ":: UseFncRules:", l.UseFncRules)
-	if !l.UseFncRules && sev > l.maxSeverity {
+	return l.logwSync(fnc, msg, sev, debLev, sev == SEVFATAL && (l.syncFatal || l.onFatal != ""))
+}
+
+// logwSync: like logw, with direct the message is written directly after
+// the queued messages
+func (l *SLogger) logwSync(fnc tFncName, msg string, sev tSeverity, debLev tDebLevel, direct bool) error {
+	const cFncName = cPckName + ".logwSync"
+	r := l.getRules()
+	prDeb(cFncName, "sev:", sev, ":: maxSeverity:", r.maxSeverity, ":: UseFncRules:", r.useFncRules)
+	if sev > r.maxSeverity {
 		prDeb(cFncName, "EXIT")
 		return nil
 	}
 	prDeb(cFncName, "WRITE:", msg)
-	lm := &SLogMsg{fnc: fnc, msg: msg, sev: sev, debLev: debLev}
-	l.msgChan <- lm
+	lm := &SLogMsg{fnc: fnc, msg: msg, sev: sev, debLev: debLev, ts: time.Now(), sessionId: l.SessionId()}
+	if direct && !l.sync {
+		// write the queued messages before the direct one
+		l.Flush()
+	}
+	l.closeLock.RLock()
+	defer l.closeLock.RUnlock()
+	if l.closed {
+		l.writeClosed(lm)
+		return nil
+	}
+	if direct || l.sync {
+		l.writeSync(lm)
+		return nil
+	}
+	l.queue.push(lm)
 	return nil
 }
 
+// writeClosed: a message logged after Destroy is counted as dropped and
+// written on stderr when it is the fallback
+func (l *SLogger) writeClosed(lm *SLogMsg) {
+	prDeb("writeClosed", "MSG: ", *lm)
+	l.queue.drop(lm)
+	if l.fallback == "stderr" {
+		writeMsg(l.stderr, log.Ldate|log.Ltime, CONSSEP, *lm)
+	}
+}
+
+// writeSync: write the message directly to all writers, the message is
+// written when the function returns
+func (l *SLogger) writeSync(lm *SLogMsg) {
+	l.syncLock.Lock()
+	defer l.syncLock.Unlock()
+	for name, lw := range l.writers {
+		if err := lw.writer.Write(*lm); err != nil {
+			l.writeFailed(name, err, *lm)
+		}
+	}
+}
+
+// Fatal: log message with severity fatal. With syncfatal or onfatal the
+// message is written directly and all writers are flushed, then the fatal
+// hook is called and the onfatal action (exit or panic) is executed
 func (l *SLogger) Fatal(fnc tFncName, msg string) {
-	l.logw(fnc, msg, SEVFATAL, 0)
+	l.fatal(fnc, msg, l.syncFatal || l.onFatal != "")
+}
+
+// FatalSync: like Fatal, but the message is always written directly and
+// all writers are flushed, also without syncfatal
+func (l *SLogger) FatalSync(fnc tFncName, msg string) {
+	l.fatal(fnc, msg, true)
+}
+
+func (l *SLogger) fatal(fnc tFncName, msg string, direct bool) {
+	l.logwSync(fnc, msg, SEVFATAL, 0, direct)
+	if !direct {
+		return
+	}
+	l.Flush()
+	l.lock.Lock()
+	hook := l.fatalHook
+	l.lock.Unlock()
+	if hook != nil {
+		hook()
+	}
+	switch l.onFatal {
+	case ONFATALEXIT:
+		osExit(l.exitCode)
+	case ONFATALPANIC:
+		panic(fmt.Sprintf("%s: %s", fnc, msg))
+	}
+}
+
+// SetFatalHook set the function called after a fatal message has been
+// written, before the onfatal action. Used to run custom shutdown
+func (l *SLogger) SetFatalHook(hook func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.fatalHook = hook
 }
 
 func (l *SLogger) Err(fnc tFncName, msg string) {
@@ -347,25 +740,96 @@ func (l *SLogger) Deb(fnc tFncName, msg string) {
 
 // Debl: log message with severity debug and input debug level
 func (l *SLogger) Debl(fnc tFncName, msg string, debLev tDebLevel) {
-	if debLev <= l.maxDebLev {
+	if debLev <= l.getRules().maxDebLev {
 		l.logw(fnc, msg, SEVDEBUG, debLev)
 	}
 }
 
-// Flush all chan data
+// Flush: wait until all messages logged before the call are written
+// by all writers, then flush the writers
 func (l *SLogger) Flush() {
-	for _, lw := range l.writers {
-		lw.writer.Flush()
+	l.FlushContext(context.Background())
+}
+
+// FlushContext: like Flush, but stop waiting when the context is done,
+// returning the context error
+func (l *SLogger) FlushContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(len(l.writers))
+	l.closeLock.RLock()
+	if l.closed {
+		// Destroy writes all the pending messages
+		l.closeLock.RUnlock()
+		return nil
+	}
+	select {
+	case l.queue.ch <- &SLogMsg{flush: wg}:
+	case <-ctx.Done():
+		l.closeLock.RUnlock()
+		return ctx.Err()
+	}
+	l.closeLock.RUnlock()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// Destroy logger, flush all chan data and destroy all writers.
+// Reopen the outputs of the writers implementing IReopener. The pending
+// messages are written before. Returns the first error
+func (l *SLogger) Reopen() error {
+	l.Flush()
+	var err error
+	for name, lw := range l.writers {
+		if rw, ok := lw.writer.(IReopener); ok {
+			if rerr := rw.Reopen(); rerr != nil && err == nil {
+				err = fmt.Errorf("logdeb: writer %q. ERR: %s", name, rerr)
+			}
+		}
+	}
+	return err
+}
+
+// Destroy logger, write all pending messages and destroy all writers.
+// Destroy can be called more than once, messages logged after Destroy
+// are dropped
 func (l *SLogger) Destroy() {
-	close(l.msgChan)
-	l.wg.Wait()
-	for _, lw := range l.writers {
-		lw.writer.Flush()
-		lw.writer.Destroy()
+	l.DestroyContext(context.Background())
+}
+
+// DestroyContext: like Destroy, but stop waiting when the context is done,
+// returning the context error. The writers are destroyed anyway when the
+// pending messages have been written
+func (l *SLogger) DestroyContext(ctx context.Context) error {
+	l.destroyOnce.Do(func() {
+		go func() {
+			l.closeLock.Lock()
+			l.closed = true
+			close(l.queue.ch)
+			l.closeLock.Unlock()
+			l.wg.Wait()
+			for _, lw := range l.writers {
+				lw.writer.Flush()
+				lw.writer.Destroy()
+			}
+			close(l.destroyed)
+		}()
+	})
+	select {
+	case <-l.destroyed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -382,6 +846,24 @@ func (sev tSeverity) String() string {
 	case SEVFATAL:
 		return "F"
 	default:
-		return "Unknown severity: " + string(sev)
+		return "Unknown severity: " + strconv.Itoa(int(sev))
+	}
+}
+
+// name: severity full name
+func (sev tSeverity) name() string {
+	switch sev {
+	case SEVDEBUG:
+		return "debug"
+	case SEVINFO:
+		return "info"
+	case SEVWARN:
+		return "warning"
+	case SEVERROR:
+		return "error"
+	case SEVFATAL:
+		return "fatal"
+	default:
+		return "sev" + strconv.Itoa(int(sev))
 	}
 }
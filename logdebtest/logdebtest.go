@@ -0,0 +1,108 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package logdebtest provides helpers to verify the messages logged
+// through logdeb, using the in-memory writer.
+//
+//	rec := logdebtest.New(t, `{"memory":{"sev":5}}`)
+//	doSomething(rec.Logger())
+//	rec.AssertLogged(t, logdeb.SEVERROR, "doSomething", "failed")
+package logdebtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malix0/logdeb"
+)
+
+// Time waited by AssertLogged for the pending messages to be written
+var WaitTimeout = time.Second
+
+// SRecorder gives access to the messages recorded by the memory writer of a logger
+type SRecorder struct {
+	l  *logdeb.SLogger
+	mw *logdeb.SMemoryWriter
+}
+
+// New creates a logger from config and returns its recorder.
+// The config must contain the memory writer. The logger is destroyed
+// when the test ends.
+func New(tb testing.TB, config string) *SRecorder {
+	tb.Helper()
+	l := logdeb.NewLogDeb(100, config)
+	tb.Cleanup(l.Destroy)
+	return Attach(tb, l)
+}
+
+// Attach returns the recorder of a logger configured with the memory writer
+func Attach(tb testing.TB, l *logdeb.SLogger) *SRecorder {
+	tb.Helper()
+	mw, ok := l.Writer("memory").(*logdeb.SMemoryWriter)
+	if !ok {
+		tb.Fatal("logdebtest: memory writer not configured")
+	}
+	return &SRecorder{l: l, mw: mw}
+}
+
+// Logger returns the recorded logger
+func (r *SRecorder) Logger() *logdeb.SLogger {
+	return r.l
+}
+
+// flush waits, up to WaitTimeout, until the pending messages are written
+func (r *SRecorder) flush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), WaitTimeout)
+	defer cancel()
+	return r.l.FlushContext(ctx)
+}
+
+// Entries flushes the logger and returns the messages written so far
+func (r *SRecorder) Entries() []logdeb.SLogEntry {
+	r.flush()
+	return r.mw.Entries()
+}
+
+// Reset flushes the logger and discards the messages written so far
+func (r *SRecorder) Reset() {
+	r.flush()
+	r.mw.Reset()
+}
+
+// find returns true if a message matching sev, fnc and substring was written.
+// An empty fnc matches any function.
+func (r *SRecorder) find(sev int, fnc string, substring string) bool {
+	for _, e := range r.mw.Entries() {
+		if int(e.Sev) == sev && (fnc == "" || e.Fnc == fnc) && strings.Contains(e.Msg, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertLogged flushes the logger and fails the test if no message with
+// severity sev, function fnc and containing substring has been written.
+// An empty fnc matches any function.
+func (r *SRecorder) AssertLogged(tb testing.TB, sev int, fnc string, substring string) {
+	tb.Helper()
+	if err := r.flush(); err != nil {
+		tb.Errorf("logdebtest: flush failed: %v", err)
+		return
+	}
+	if !r.find(sev, fnc, substring) {
+		tb.Errorf("logdebtest: message not logged. sev: %d fnc: %q substring: %q\n GOT => %v", sev, fnc, substring, r.mw.Entries())
+	}
+}
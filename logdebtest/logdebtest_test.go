@@ -0,0 +1,74 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdebtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/malix0/logdeb"
+)
+
+// sFailTB records the failure instead of failing the test
+type sFailTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *sFailTB) Helper() {}
+
+func (f *sFailTB) Errorf(format string, args ...interface{}) {
+	f.failed = true
+}
+
+func TestAssertLogged(t *testing.T) {
+	rec := New(t, `{"memory":{"sev":4}}`)
+	rec.Logger().Info("TestAssertLogged.fnc", "recorded info message")
+	rec.AssertLogged(t, logdeb.SEVINFO, "TestAssertLogged.fnc", "info message")
+	rec.AssertLogged(t, logdeb.SEVINFO, "", "recorded")
+	rec.Reset()
+	if len(rec.Entries()) != 0 {
+		t.Errorf("entries not discarded by Reset: %v", rec.Entries())
+	}
+}
+
+func TestAssertLoggedFail(t *testing.T) {
+	WaitTimeout = 10 * time.Millisecond
+	defer func() { WaitTimeout = time.Second }()
+	rec := New(t, `{"memory":{"sev":4}}`)
+	rec.Logger().Deb("TestAssertLoggedFail.fnc", "debug message not recorded")
+	ft := &sFailTB{TB: t}
+	rec.AssertLogged(ft, logdeb.SEVDEBUG, "TestAssertLoggedFail.fnc", "not recorded")
+	if !ft.failed {
+		t.Error("AssertLogged must fail for a message not written")
+	}
+}
+
+func TestEntriesReset(t *testing.T) {
+	rec := New(t, `{"memory":{"sev":4}}`)
+	for i := 0; i < 100; i++ {
+		rec.Logger().Info("TestEntriesReset.fnc", "pending info message")
+	}
+	// the queued messages are written before returning the entries
+	if n := len(rec.Entries()); n != 100 {
+		t.Errorf("expected 100 entries, got %d", n)
+	}
+	rec.Logger().Info("TestEntriesReset.fnc", "pending info message")
+	// the queued messages are discarded by Reset
+	rec.Reset()
+	if entries := rec.Entries(); len(entries) != 0 {
+		t.Errorf("entries not discarded by Reset: %v", entries)
+	}
+}
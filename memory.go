@@ -0,0 +1,89 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"sync"
+	"time"
+)
+
+// SLogEntry is a message recorded by the memory writer
+type SLogEntry struct {
+	Time   time.Time
+	Fnc    string
+	Sev    tSeverity
+	DebLev tDebLevel
+	Msg    string
+}
+
+// SMemoryWriter keeps the written messages in memory
+type SMemoryWriter struct {
+	sync.Mutex
+	mainLogger *SLogger
+	entries    []SLogEntry
+}
+
+// NewMemoryWriter: create SMemoryWriter returning as ILogWriter.
+func NewMemoryWriter() ILogWriter {
+	return new(SMemoryWriter)
+}
+
+// Init memory logger.
+func (mw *SMemoryWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	mw.mainLogger = logger
+	return nil
+}
+
+// Write message in memory.
+func (mw *SMemoryWriter) Write(msg SLogMsg) error {
+	prDeb("memory.go - Write", "MSG: ", msg)
+	if !mw.mainLogger.MustWrite("memory", msg) {
+		return nil
+	}
+	mw.Lock()
+	defer mw.Unlock()
+	mw.entries = append(mw.entries, SLogEntry{Time: msg.ts, Fnc: string(msg.fnc), Sev: msg.sev, DebLev: msg.debLev, Msg: msg.msg})
+	return nil
+}
+
+// Entries returns a copy of the written messages
+func (mw *SMemoryWriter) Entries() []SLogEntry {
+	mw.Lock()
+	defer mw.Unlock()
+	entries := make([]SLogEntry, len(mw.entries))
+	copy(entries, mw.entries)
+	return entries
+}
+
+// Reset discards the written messages
+func (mw *SMemoryWriter) Reset() {
+	mw.Lock()
+	defer mw.Unlock()
+	mw.entries = nil
+}
+
+// implementing method. empty.
+func (mw *SMemoryWriter) Destroy() {
+
+}
+
+// implementing method. empty.
+func (mw *SMemoryWriter) Flush() {
+
+}
+
+func init() {
+	CreateWriter("memory", NewMemoryWriter)
+}
@@ -0,0 +1,234 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryDeb1(t *testing.T) {
+	name := "TestMemoryDeb1"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"memory":{"sev":5}}`)
+	l.Deb(fnc, "test memory debug")
+	l.Err(fnc, "test memory error")
+	// Don't write this because the default DebugLevel is 1
+	l.Debl(fnc, "test memory with debug level 2", DLE)
+	l.Destroy()
+	mw := l.Writer("memory").(*SMemoryWriter)
+	entries := mw.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("%s: expected 2 entries, got %d: %v", name, len(entries), entries)
+	}
+	if entries[0].Fnc != name || entries[0].Sev != SEVDEBUG || entries[0].Msg != "test memory debug" {
+		t.Errorf("%s: unexpected debug entry %v", name, entries[0])
+	}
+	if entries[1].Sev != SEVERROR || entries[1].Msg != "test memory error" {
+		t.Errorf("%s: unexpected error entry %v", name, entries[1])
+	}
+	mw.Reset()
+	if len(mw.Entries()) != 0 {
+		t.Errorf("%s: entries not discarded by Reset", name)
+	}
+}
+
+func TestMemorySync(t *testing.T) {
+	name := "TestMemorySync"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"async":false}, "memory":{"sev":5}}`)
+	defer l.Destroy()
+	mw := l.Writer("memory").(*SMemoryWriter)
+	l.Deb(fnc, "test memory sync")
+	// the message is written when the call returns
+	if entries := mw.Entries(); len(entries) != 1 || entries[0].Msg != "test memory sync" {
+		t.Errorf("%s: message not written synchronously %v", name, entries)
+	}
+}
+
+func TestMemorySyncFatal(t *testing.T) {
+	name := "TestMemorySyncFatal"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"syncfatal":true}, "memory":{"sev":5}}`)
+	defer l.Destroy()
+	mw := l.Writer("memory").(*SMemoryWriter)
+	l.Fatal(fnc, "test memory sync fatal")
+	if entries := mw.Entries(); len(entries) != 1 || entries[0].Sev != SEVFATAL {
+		t.Errorf("%s: fatal message not written synchronously %v", name, entries)
+	}
+}
+
+func TestMemoryFatalExit(t *testing.T) {
+	name := "TestMemoryFatalExit"
+	fnc := tFncName(name)
+	var calls []string
+	osExit = func(code int) {
+		calls = append(calls, fmt.Sprintf("exit %d", code))
+	}
+	defer func() { osExit = os.Exit }()
+	l := NewLogDeb(10, `{"main":{"onfatal":"exit", "exitcode":3}, "memory":{"sev":5}}`)
+	defer l.Destroy()
+	mw := l.Writer("memory").(*SMemoryWriter)
+	l.SetFatalHook(func() {
+		calls = append(calls, fmt.Sprintf("hook %d", len(mw.Entries())))
+	})
+	l.Fatal(fnc, "test memory fatal exit")
+	// the hook is called when the message is written, then the process exits
+	if len(calls) != 2 || calls[0] != "hook 1" || calls[1] != "exit 3" {
+		t.Errorf("%s: unexpected calls %v", name, calls)
+	}
+}
+
+func TestMemoryFatalPanic(t *testing.T) {
+	name := "TestMemoryFatalPanic"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"onfatal":"panic"}, "memory":{"sev":5}}`)
+	defer l.Destroy()
+	defer func() {
+		if r := recover(); r != "TestMemoryFatalPanic: test memory fatal panic" {
+			t.Errorf("%s: unexpected panic %v", name, r)
+		}
+		if len(l.Writer("memory").(*SMemoryWriter).Entries()) != 1 {
+			t.Errorf("%s: fatal message not written before panic", name)
+		}
+	}()
+	l.Fatal(fnc, "test memory fatal panic")
+}
+
+func TestMemoryDestroy(t *testing.T) {
+	name := "TestMemoryDestroy"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"memory":{"sev":5}}`)
+	l.Deb(fnc, "test memory destroy")
+	l.Destroy()
+	// Destroy is idempotent and the messages logged later are dropped
+	l.Destroy()
+	l.Err(fnc, "test memory after destroy")
+	l.Flush()
+	if entries := l.Writer("memory").(*SMemoryWriter).Entries(); len(entries) != 1 || entries[0].Msg != "test memory destroy" {
+		t.Errorf("%s: unexpected entries %v", name, entries)
+	}
+	if d := l.DroppedBySeverity(); d[SEVERROR] != 1 {
+		t.Errorf("%s: message after destroy not counted %v", name, d)
+	}
+}
+
+func TestMemoryDestroyConcurrent(t *testing.T) {
+	name := "TestMemoryDestroyConcurrent"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"memory":{"sev":5}}`)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				l.Deb(fnc, "test memory destroy concurrent")
+			}
+		}()
+	}
+	l.Destroy()
+	wg.Wait()
+	l.Destroy()
+	n := uint64(len(l.Writer("memory").(*SMemoryWriter).Entries()))
+	if d := l.DroppedBySeverity(); n+d[SEVDEBUG] != 800 {
+		t.Errorf("%s: %d messages written and %d dropped", name, n, d[SEVDEBUG])
+	}
+}
+
+func TestMemoryConcurrentChanges(t *testing.T) {
+	name := "TestMemoryConcurrentChanges"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"usefncrules":true}, "memory":{"sev":5, "dlev":3, "fncrules":{"TestMemoryConcurrentChanges.dontwriteme":{"sev":1}}}}`)
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	// change the rules and the session while logging
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			l.SetSeverity(tSeverity(i%SEVDEBUG + 1))
+			l.SetDebugLevel(tDebLevel(i%DLVVV + 1))
+			l.SetUseFncRules(i%2 == 0)
+			l.SetSessionId(fmt.Sprintf("session%d", i))
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				l.Deb(fnc, "test memory concurrent debug")
+				l.Debl(fnc+".dontwriteme", "test memory concurrent debug level", DLE)
+				l.Err(fnc, "test memory concurrent error")
+				l.SessionId()
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	wg.Wait()
+	// the last rules are used
+	l.SetUseFncRules(false)
+	l.SetSessionId(name)
+	l.Err(fnc, "test memory concurrent last")
+	l.Destroy()
+	entries := l.Writer("memory").(*SMemoryWriter).Entries()
+	for _, e := range entries {
+		if e.Fnc != name && e.Fnc != name+".dontwriteme" {
+			t.Fatalf("%s: unexpected entry %v", name, e)
+		}
+	}
+	if e := entries[len(entries)-1]; e.Msg != "test memory concurrent last" {
+		t.Errorf("%s: unexpected last entry %v", name, e)
+	}
+}
+
+func TestMemoryLogTime(t *testing.T) {
+	name := "TestMemoryLogTime"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"memory":{"sev":5}}`)
+	defer l.Destroy()
+	mw := l.Writer("memory").(*SMemoryWriter)
+	// the entry has the time of the log call, not of the write
+	ts := time.Now().Add(-time.Hour)
+	mw.Write(SLogMsg{fnc: fnc, msg: "test memory log time", sev: SEVINFO, ts: ts})
+	if entries := mw.Entries(); len(entries) != 1 || !entries[0].Time.Equal(ts) {
+		t.Errorf("%s: unexpected entries %v", name, entries)
+	}
+}
+
+func TestMemoryFatalSync(t *testing.T) {
+	name := "TestMemoryFatalSync"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"memory":{"sev":5}}`)
+	defer l.Destroy()
+	mw := l.Writer("memory").(*SMemoryWriter)
+	l.Err(fnc, "test memory error")
+	l.FatalSync(fnc, "test memory fatal sync")
+	// the queued message and then the fatal one are written when the call returns
+	entries := mw.Entries()
+	if len(entries) != 2 || entries[0].Sev != SEVERROR || entries[1].Sev != SEVFATAL {
+		t.Errorf("%s: fatal message not written synchronously %v", name, entries)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const NETSEP = "|||"
+
+// Net writer defaults
+const (
+	NETDEFRETRYBUF   = 1000  // messages kept while disconnected
+	NETDEFBACKOFFMIN = 100   // milliseconds
+	NETDEFBACKOFFMAX = 30000 // milliseconds
+	NETDEFTIMEOUT    = 5000  // milliseconds
+)
+
+// SNetWriter streams messages to a remote collector over TCP or UDP
+type SNetWriter struct {
+	sync.Mutex
+	l             *log.Logger
+	buf           bytes.Buffer // formatted text message
+	mainLogger    *SLogger
+	network       string // tcp or udp
+	addr          string // host:port
+	json          bool   // write messages as JSON lines
+	flags         int
+	useTLS        bool
+	tlsSkipVerify bool
+	timeout       time.Duration
+	backoff       sBackoff      // reconnect backoff
+	retryBuf      int           // maximum number of pending messages
+	pending       []sNetPending // messages not yet sent
+	dropped       uint64        // messages dropped because the retry buffer is full
+	conn          net.Conn
+}
+
+// sNetPending is a message not yet sent, kept for the fallback writer
+type sNetPending struct {
+	msg  SLogMsg
+	line []byte // formatted message
+}
+
+// NewNetWriter: create SNetWriter returning as ILogWriter.
+func NewNetWriter() ILogWriter {
+	nw := new(SNetWriter)
+	nw.network = "tcp"
+	nw.flags = log.Ldate | log.Ltime
+	nw.l = log.New(&nw.buf, "", nw.flags)
+	nw.retryBuf = NETDEFRETRYBUF
+	nw.backoff.min = NETDEFBACKOFFMIN * time.Millisecond
+	nw.backoff.max = NETDEFBACKOFFMAX * time.Millisecond
+	nw.timeout = NETDEFTIMEOUT * time.Millisecond
+	return nw
+}
+
+// getConfig: extract configuration
+func (nw *SNetWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["network"]; t {
+			nw.network = strings.ToLower(v.(string))
+		}
+		if v, t := confout["addr"]; t {
+			nw.addr = v.(string)
+		}
+		if v, t := confout["format"]; t {
+			nw.json = strings.ToLower(v.(string)) == "json"
+		}
+		if v, t := confout["flags"]; t {
+			nw.flags = int(v.(float64))
+			nw.l.SetFlags(nw.flags)
+		}
+		if v, t := confout["tls"]; t {
+			nw.useTLS = v.(bool)
+		}
+		if v, t := confout["tlsskipverify"]; t {
+			nw.tlsSkipVerify = v.(bool)
+		}
+		if v, t := confout["retrybuf"]; t {
+			nw.retryBuf = int(v.(float64))
+		}
+		if v, t := confout["backoffmin"]; t {
+			nw.backoff.min = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["backoffmax"]; t {
+			nw.backoff.max = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["timeout"]; t {
+			nw.timeout = time.Duration(v.(float64)) * time.Millisecond
+		}
+	}
+	if nw.network != "tcp" && nw.network != "udp" {
+		return fmt.Errorf("unsupported network %q", nw.network)
+	}
+	if nw.retryBuf < 1 {
+		nw.retryBuf = 1
+	}
+	if nw.useTLS && nw.network != "tcp" {
+		return errors.New("tls requires tcp network")
+	}
+	return nil
+}
+
+// Init net logger.
+func (nw *SNetWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	nw.mainLogger = logger
+	if err := nw.getConfig(config); err != nil {
+		return err
+	}
+	if len(nw.addr) == 0 {
+		return errors.New("addr not configured")
+	}
+	return nil
+}
+
+// dial: connect to the collector, waiting the backoff time after a failure
+func (nw *SNetWriter) dial() error {
+	if nw.backoff.waiting() {
+		return errors.New("waiting to reconnect")
+	}
+	prDeb("net.go - dial", "network:", nw.network, "addr:", nw.addr)
+	var err error
+	dialer := &net.Dialer{Timeout: nw.timeout}
+	if nw.useTLS {
+		nw.conn, err = tls.DialWithDialer(dialer, nw.network, nw.addr, &tls.Config{InsecureSkipVerify: nw.tlsSkipVerify})
+	} else {
+		nw.conn, err = dialer.Dial(nw.network, nw.addr)
+	}
+	if err != nil {
+		nw.conn = nil
+		nw.fail()
+		return err
+	}
+	nw.backoff.reset()
+	return nil
+}
+
+// fail: close the connection and compute the next reconnect time with exponential backoff
+func (nw *SNetWriter) fail() {
+	if nw.conn != nil {
+		nw.conn.Close()
+		nw.conn = nil
+	}
+	nw.backoff.fail()
+}
+
+// send: write the pending messages, the ones not sent are kept for the next try
+func (nw *SNetWriter) send() error {
+	if len(nw.pending) == 0 {
+		return nil
+	}
+	if nw.conn == nil {
+		if err := nw.dial(); err != nil {
+			return err
+		}
+	}
+	for len(nw.pending) > 0 {
+		if nw.timeout > 0 {
+			nw.conn.SetWriteDeadline(time.Now().Add(nw.timeout))
+		}
+		if _, err := nw.conn.Write(nw.pending[0].line); err != nil {
+			prDeb("net.go - send", "Write error. Err: ", err)
+			nw.fail()
+			return err
+		}
+		nw.pending[0] = sNetPending{}
+		nw.pending = nw.pending[1:]
+	}
+	return nil
+}
+
+// format: format the message as text or JSON line
+func (nw *SNetWriter) format(msg SLogMsg) ([]byte, error) {
+	if nw.json {
+		b, err := msgJSON(msg)
+		return append(b, '\n'), err
+	}
+	nw.buf.Reset()
+	if err := writeMsg(nw.l, nw.flags, NETSEP, msg); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), nw.buf.Bytes()...), nil
+}
+
+// Write message to the collector. While disconnected the message is kept
+// in the retry buffer, when the buffer is full the oldest message is dropped
+// and given to the fallback writer.
+func (nw *SNetWriter) Write(msg SLogMsg) error {
+	prDeb("net.go - Write", "MSG: ", msg)
+	if !nw.mainLogger.MustWrite("net", msg) {
+		return nil
+	}
+	nw.Lock()
+	defer nw.Unlock()
+	line, err := nw.format(msg)
+	if err != nil {
+		return err
+	}
+	nw.pending = append(nw.pending, sNetPending{msg: msg, line: line})
+	err = nw.send()
+	if n := len(nw.pending) - nw.retryBuf; n > 0 {
+		var msgs []SLogMsg
+		for _, p := range nw.pending[:n] {
+			msgs = append(msgs, p.msg)
+		}
+		nw.pending = nw.pending[n:]
+		nw.dropped += uint64(n)
+		nw.mainLogger.writeFailed("net", fmt.Errorf("retry buffer full, message dropped. ERR: %s", err), msgs...)
+	}
+	return nil
+}
+
+// lost: the pending messages can't be sent anymore
+func (nw *SNetWriter) lost(err error) {
+	if len(nw.pending) == 0 {
+		return
+	}
+	msgs := make([]SLogMsg, 0, len(nw.pending))
+	for _, p := range nw.pending {
+		msgs = append(msgs, p.msg)
+	}
+	nw.dropped += uint64(len(msgs))
+	nw.pending = nil
+	nw.mainLogger.writeFailed("net", err, msgs...)
+}
+
+// Dropped returns the number of messages dropped because the retry buffer was full
+func (nw *SNetWriter) Dropped() uint64 {
+	nw.Lock()
+	defer nw.Unlock()
+	return nw.dropped
+}
+
+// Destroy try to send the pending messages and close the connection.
+// The messages not sent are given to the fallback writer.
+func (nw *SNetWriter) Destroy() {
+	nw.Lock()
+	defer nw.Unlock()
+	if err := nw.send(); err != nil {
+		nw.lost(err)
+	}
+	if nw.conn != nil {
+		nw.conn.Close()
+		nw.conn = nil
+	}
+}
+
+// Flush try to send the pending messages, the ones not sent are kept.
+func (nw *SNetWriter) Flush() {
+	nw.Lock()
+	defer nw.Unlock()
+	if err := nw.send(); err != nil {
+		nw.mainLogger.ReportError("net", err)
+	}
+}
+
+func init() {
+	CreateWriter("net", NewNetWriter)
+}
@@ -0,0 +1,208 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stand-in collector, accept connections and send the received lines to a channel
+func startCollector(t *testing.T, addr string) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("can not listen on %s. ERR: %s", addr, err)
+	}
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return ln, lines
+}
+
+// Read count lines from the collector
+func readCollector(t *testing.T, lines chan string, count int) string {
+	var out []string
+	for i := 0; i < count; i++ {
+		select {
+		case line := <-lines:
+			out = append(out, line)
+		case <-time.After(time.Second):
+			t.Fatalf("collector timeout, got %d lines of %d: %v", i, count, out)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// waitSent: flush the logger until the net writer has sent the pending messages
+func waitSent(t *testing.T, l *SLogger) {
+	nw := l.Writer("net").(*SNetWriter)
+	deadline := time.Now().Add(time.Second)
+	for {
+		l.Flush()
+		nw.Lock()
+		n := len(nw.pending)
+		nw.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("net writer timeout, %d messages pending", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNetTCP(t *testing.T) {
+	name := "TestNetTCP"
+	fnc := tFncName(name)
+	ln, lines := startCollector(t, "127.0.0.1:0")
+	defer ln.Close()
+	config := fmt.Sprintf(`{"net":{"flags":0, "sev":5, "addr":%q}}`, ln.Addr().String())
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net debug"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, sev: SEVERROR, msg: "test net error"}, true},
+	}
+	executeTest(config, tmsgs)
+	out := readCollector(t, lines, 2)
+	checkResult(t, out, name, NETSEP, tmsgs)
+}
+
+func TestNetJSON(t *testing.T) {
+	name := "TestNetJSON"
+	ln, lines := startCollector(t, "127.0.0.1:0")
+	defer ln.Close()
+	config := fmt.Sprintf(`{"net":{"sev":5, "dlev":2, "addr":%q, "format":"json"}}`, ln.Addr().String())
+	l := NewLogDeb(10, config)
+	l.SetSessionId(name)
+	l.Debl(tFncName(name), "test net json", DLE)
+	l.Destroy()
+	var jm sJSONMsg
+	out := readCollector(t, lines, 1)
+	if err := json.Unmarshal([]byte(out), &jm); err != nil {
+		t.Fatalf("%s: invalid JSON %q. ERR: %s", name, out, err)
+	}
+	if jm.Fnc != tFncName(name) || jm.Severity != "debug" || jm.DebugLevel != DLE || jm.SessionId != name || jm.Msg != "test net json" {
+		t.Errorf("%s: unexpected message %v", name, jm)
+	}
+}
+
+func TestNetReconnect(t *testing.T) {
+	name := "TestNetReconnect"
+	fnc := tFncName(name)
+	// get a free address, the collector is not listening yet
+	ln, _ := startCollector(t, "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	config := fmt.Sprintf(`{"net":{"flags":0, "sev":5, "addr":%q, "backoffmin":1, "backoffmax":2, "retrybuf":2}}`, addr)
+	l := NewLogDeb(10, config)
+	// the first message is dropped because the retry buffer keeps 2 messages
+	l.Deb(fnc, "test net dropped")
+	l.Deb(fnc, "test net buffered 1")
+	l.Deb(fnc, "test net buffered 2")
+	// the messages are processed before starting the collector
+	l.Flush()
+	ln, lines := startCollector(t, addr)
+	defer ln.Close()
+	waitSent(t, l)
+	l.Deb(fnc, "test net connected")
+	l.Destroy()
+	out := readCollector(t, lines, 3)
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net dropped"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net buffered 1"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net buffered 2"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net connected"}, true},
+	}
+	checkResult(t, out, name, NETSEP, tmsgs)
+	if d := l.Writer("net").(*SNetWriter).Dropped(); d != 1 {
+		t.Errorf("%s: expected 1 dropped message, got %d", name, d)
+	}
+}
+
+func TestNetRetryBufFallback(t *testing.T) {
+	name := "TestNetRetryBufFallback"
+	fnc := tFncName(name)
+	ln, _ := startCollector(t, "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	config := fmt.Sprintf(`{"main":{"fallback":"stderr"}, "net":{"flags":0, "sev":5, "addr":%q, "backoffmin":1, "backoffmax":2, "retrybuf":1}}`, addr)
+	preTestConsole()
+	l := NewLogDeb(10, config)
+	// the first message is dropped from the retry buffer and written by the fallback
+	l.Deb(fnc, "test net fallback m1")
+	l.Deb(fnc, "test net fallback m2")
+	l.Flush()
+	ln, lines := startCollector(t, addr)
+	defer ln.Close()
+	waitSent(t, l)
+	l.Deb(fnc, "test net fallback m3")
+	l.Destroy()
+	postTestConsole()
+	<-outC
+	errOut := <-errC
+	out := readCollector(t, lines, 2)
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net fallback m1"}, false},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net fallback m2"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net fallback m3"}, true},
+	}
+	checkResult(t, out, name, NETSEP, tmsgs)
+	if !strings.Contains(errOut, "test net fallback m1") || strings.Contains(errOut, "m2") || strings.Contains(errOut, "m3") {
+		t.Errorf("%s: unexpected fallback messages %q", name, errOut)
+	}
+	if d := l.DroppedMessages()["net"]; d != 1 {
+		t.Errorf("%s: expected 1 dropped message, got %d", name, d)
+	}
+}
+
+func TestNetUDP(t *testing.T) {
+	name := "TestNetUDP"
+	fnc := tFncName(name)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: can not listen. ERR: %s", name, err)
+	}
+	defer conn.Close()
+	config := fmt.Sprintf(`{"net":{"flags":0, "sev":5, "network":"udp", "addr":%q}}`, conn.LocalAddr().String())
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test net udp"}, true},
+	}
+	executeTest(config, tmsgs)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("%s: read error. ERR: %s", name, err)
+	}
+	checkResult(t, string(buf[:n]), name, NETSEP, tmsgs)
+}
@@ -0,0 +1,179 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow policies, applied when the message queue is full
+const (
+	OVERFLOWBLOCK     = "block"     // wait until there is room in the queue
+	OVERFLOWDROPNEW   = "dropnew"   // drop the new message
+	OVERFLOWDROPOLD   = "dropold"   // drop the oldest message in the queue
+	OVERFLOWTIMEOUT   = "timeout"   // wait until the timeout, then drop the new message
+	OVERFLOWDROPBELOW = "dropbelow" // drop the new message when less severe than dropSev, otherwise wait
+)
+
+// sMsgQueue is a message queue with an overflow policy
+type sMsgQueue struct {
+	ch       chan *SLogMsg
+	overflow string
+	timeout  time.Duration        // wait time of timeout policy
+	dropSev  tSeverity            // messages with severity above dropSev are dropped by dropbelow policy
+	dropped  [SEVDEBUG + 1]uint64 // dropped messages by severity
+	onDrop   func()               // called for each dropped message, if set
+}
+
+// newMsgQueue: create a message queue with block overflow policy
+func newMsgQueue(size int64) *sMsgQueue {
+	if size < 0 {
+		size = 0
+	}
+	return &sMsgQueue{ch: make(chan *SLogMsg, size), overflow: OVERFLOWBLOCK}
+}
+
+// setOverflow: set the overflow policy, an empty policy means block.
+// The timeout policy needs a positive timeout, dropbelow drops the messages
+// less severe than error when dropSev is 0
+func (q *sMsgQueue) setOverflow(overflow string, timeout time.Duration, dropSev tSeverity) error {
+	switch overflow {
+	case "":
+		overflow = OVERFLOWBLOCK
+	case OVERFLOWBLOCK, OVERFLOWDROPNEW, OVERFLOWDROPOLD, OVERFLOWTIMEOUT, OVERFLOWDROPBELOW:
+	default:
+		return fmt.Errorf("unknown overflow policy %q", overflow)
+	}
+	if overflow == OVERFLOWTIMEOUT && timeout <= 0 {
+		return fmt.Errorf("overflow policy %q needs a positive overflowtimeout", overflow)
+	}
+	if overflow == OVERFLOWDROPBELOW && dropSev == 0 {
+		// errors and fatals are never dropped by default
+		dropSev = SEVERROR
+	}
+	if dropSev < 0 || dropSev > SEVDEBUG {
+		return fmt.Errorf("invalid dropsev %d", dropSev)
+	}
+	q.overflow = overflow
+	q.timeout = timeout
+	q.dropSev = dropSev
+	return nil
+}
+
+// getConfig: extract queue size and overflow policy from writer configuration
+func (q *sMsgQueue) getConfig(config map[string]interface{}) error {
+	overflow := ""
+	var timeout time.Duration
+	var dropSev tSeverity
+	if v, t := config["queuesize"]; t {
+		if v.(float64) < 0 {
+			return fmt.Errorf("invalid queuesize %v", v)
+		}
+		q.ch = make(chan *SLogMsg, int(v.(float64)))
+	}
+	if v, t := config["overflow"]; t {
+		overflow = strings.ToLower(v.(string))
+	}
+	if v, t := config["overflowtimeout"]; t {
+		timeout = time.Duration(v.(float64)) * time.Millisecond
+	}
+	if v, t := config["dropsev"]; t {
+		dropSev = tSeverity(v.(float64))
+	}
+	return q.setOverflow(overflow, timeout, dropSev)
+}
+
+// drop: count the dropped message
+func (q *sMsgQueue) drop(lm *SLogMsg) {
+	prDeb("queue.go - drop", "MSG: ", *lm)
+	if lm.sev >= 0 && int(lm.sev) < len(q.dropped) {
+		atomic.AddUint64(&q.dropped[lm.sev], 1)
+	}
+	if q.onDrop != nil {
+		q.onDrop()
+	}
+}
+
+// push: add the message to the queue applying the overflow policy.
+// Returns false when the message has been dropped
+func (q *sMsgQueue) push(lm *SLogMsg) bool {
+	switch q.overflow {
+	case OVERFLOWDROPNEW:
+		select {
+		case q.ch <- lm:
+			return true
+		default:
+		}
+	case OVERFLOWDROPOLD:
+		for {
+			select {
+			case q.ch <- lm:
+				return true
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				if old.flush != nil {
+					// flush markers are never dropped
+					q.ch <- old
+					q.ch <- lm
+					return true
+				}
+				q.drop(old)
+			default:
+			}
+		}
+	case OVERFLOWTIMEOUT:
+		select {
+		case q.ch <- lm:
+			return true
+		default:
+		}
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- lm:
+			return true
+		case <-timer.C:
+		}
+	case OVERFLOWDROPBELOW:
+		if lm.sev <= q.dropSev {
+			q.ch <- lm
+			return true
+		}
+		select {
+		case q.ch <- lm:
+			return true
+		default:
+		}
+	default:
+		q.ch <- lm
+		return true
+	}
+	q.drop(lm)
+	return false
+}
+
+// droppedBySeverity: dropped messages by severity
+func (q *sMsgQueue) droppedBySeverity() map[tSeverity]uint64 {
+	dropped := make(map[tSeverity]uint64, SEVDEBUG)
+	for sev := tSeverity(SEVFATAL); sev <= SEVDEBUG; sev++ {
+		dropped[sev] = atomic.LoadUint64(&q.dropped[sev])
+	}
+	return dropped
+}
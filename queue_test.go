@@ -0,0 +1,127 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"testing"
+	"time"
+)
+
+// Create a full queue of size 1 with the overflow policy
+func fullTestQueue(t *testing.T, overflow string, dropSev tSeverity) *sMsgQueue {
+	q := newMsgQueue(1)
+	if err := q.setOverflow(overflow, 5*time.Millisecond, dropSev); err != nil {
+		t.Fatalf("%s: %s", overflow, err)
+	}
+	if !q.push(&SLogMsg{msg: "first", sev: SEVINFO}) {
+		t.Fatalf("%s: first message dropped", overflow)
+	}
+	return q
+}
+
+func TestQueueDropNew(t *testing.T) {
+	q := fullTestQueue(t, OVERFLOWDROPNEW, 0)
+	if q.push(&SLogMsg{msg: "second", sev: SEVDEBUG}) {
+		t.Error("dropnew: second message not dropped")
+	}
+	if lm := <-q.ch; lm.msg != "first" {
+		t.Errorf("dropnew: unexpected message in queue %q", lm.msg)
+	}
+	if d := q.droppedBySeverity(); d[SEVDEBUG] != 1 || d[SEVINFO] != 0 {
+		t.Errorf("dropnew: unexpected dropped messages %v", d)
+	}
+}
+
+func TestQueueDropOld(t *testing.T) {
+	q := fullTestQueue(t, OVERFLOWDROPOLD, 0)
+	if !q.push(&SLogMsg{msg: "second", sev: SEVDEBUG}) {
+		t.Error("dropold: second message dropped")
+	}
+	if lm := <-q.ch; lm.msg != "second" {
+		t.Errorf("dropold: unexpected message in queue %q", lm.msg)
+	}
+	if d := q.droppedBySeverity(); d[SEVINFO] != 1 || d[SEVDEBUG] != 0 {
+		t.Errorf("dropold: unexpected dropped messages %v", d)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	q := fullTestQueue(t, OVERFLOWTIMEOUT, 0)
+	start := time.Now()
+	if q.push(&SLogMsg{msg: "second", sev: SEVERROR}) {
+		t.Error("timeout: second message not dropped")
+	}
+	if time.Since(start) < 5*time.Millisecond {
+		t.Error("timeout: message dropped before the timeout")
+	}
+	if d := q.droppedBySeverity(); d[SEVERROR] != 1 {
+		t.Errorf("timeout: unexpected dropped messages %v", d)
+	}
+}
+
+func TestQueueDropBelow(t *testing.T) {
+	q := fullTestQueue(t, OVERFLOWDROPBELOW, SEVWARN)
+	if q.push(&SLogMsg{msg: "info", sev: SEVINFO}) {
+		t.Error("dropbelow: info message not dropped")
+	}
+	// the warning waits for room in the queue
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-q.ch
+	}()
+	if !q.push(&SLogMsg{msg: "warning", sev: SEVWARN}) {
+		t.Error("dropbelow: warning message dropped")
+	}
+	if lm := <-q.ch; lm.msg != "warning" {
+		t.Errorf("dropbelow: unexpected message in queue %q", lm.msg)
+	}
+	if d := q.droppedBySeverity(); d[SEVINFO] != 1 || d[SEVWARN] != 0 {
+		t.Errorf("dropbelow: unexpected dropped messages %v", d)
+	}
+}
+
+func TestQueueUnknownOverflow(t *testing.T) {
+	if err := newMsgQueue(1).setOverflow("dropall", 0, 0); err == nil {
+		t.Error("unknown overflow policy accepted")
+	}
+}
+
+func TestQueueDropBelowDefault(t *testing.T) {
+	q := fullTestQueue(t, OVERFLOWDROPBELOW, 0)
+	if q.dropSev != SEVERROR {
+		t.Errorf("dropbelow: unexpected default dropsev %d", q.dropSev)
+	}
+	if q.push(&SLogMsg{msg: "warning", sev: SEVWARN}) {
+		t.Error("dropbelow: warning message not dropped")
+	}
+	// the fatal waits for room in the queue
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-q.ch
+	}()
+	if !q.push(&SLogMsg{msg: "fatal", sev: SEVFATAL}) {
+		t.Error("dropbelow: fatal message dropped")
+	}
+}
+
+func TestQueueTimeoutRequired(t *testing.T) {
+	if err := newMsgQueue(1).setOverflow(OVERFLOWTIMEOUT, 0, 0); err == nil {
+		t.Error("timeout overflow policy accepted without timeout")
+	}
+	q := newMsgQueue(1)
+	if err := q.getConfig(map[string]interface{}{"overflow": "timeout"}); err == nil {
+		t.Error("timeout overflow policy accepted without overflowtimeout")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"fmt"
+)
+
+// SRule is a severity and debug level rule
+type SRule struct {
+	Severity   tSeverity `json:"sev"`
+	DebugLevel tDebLevel `json:"dlev"`
+}
+
+// SWriterRules are the rules of a writer
+type SWriterRules struct {
+	SRule
+	FncRules map[tFncName]SRule `json:"fncrules,omitempty"`
+}
+
+// SRules are the logger rules, with the effective values: a writer rule
+// without severity or debug level uses the main rule value and a function
+// rule uses the writer rule value
+type SRules struct {
+	Main          SRule                   `json:"main"`
+	UseFncRules   bool                    `json:"usefncrules"`
+	Writers       map[string]SWriterRules `json:"writers"`
+	MaxSeverity   tSeverity               `json:"maxsev"`  // messages more verbose are discarded immediately
+	MaxDebugLevel tDebLevel               `json:"maxdlev"` // debug messages more verbose are discarded immediately
+}
+
+// setMax: compute the maximum severity and debug level of the main, writer
+// and, when used, function rules. The main rule is included because writers
+// may use less severe messages, like the smtp writer context lines. Only
+// the rules with debug severity define the maximum debug level
+func (r *sRules) setMax() {
+	r.maxSeverity = 0
+	r.maxDebLev = 0
+	r.addMax(r.main)
+	for _, wr := range r.writers {
+		er := wr.get(r.main)
+		r.addMax(er)
+		if r.useFncRules {
+			for _, fr := range wr.FncRules {
+				r.addMax(fr.get(er))
+			}
+		}
+	}
+	prDeb("setMax", "maxSev:", r.maxSeverity, "maxDebLev:", r.maxDebLev)
+}
+
+// addMax: raise the maximum values to the effective rule values
+func (r *sRules) addMax(er sBaseRule) {
+	if er.Severity > r.maxSeverity {
+		r.maxSeverity = er.Severity
+	}
+	if er.Severity == SEVDEBUG && er.DebugLevel > r.maxDebLev {
+		r.maxDebLev = er.DebugLevel
+	}
+}
+
+// checkRule: check the rule values, zero means the parent rule value
+func checkRule(sev tSeverity, debLev tDebLevel) error {
+	if sev < 0 || sev > SEVDEBUG {
+		return fmt.Errorf("logdeb: invalid severity %d", sev)
+	}
+	if debLev < 0 {
+		return fmt.Errorf("logdeb: invalid debug level %d", debLev)
+	}
+	return nil
+}
+
+// checkMainRule: check the main rule values, the main rule has no parent
+// rule so zero values are not valid
+func checkMainRule(r sBaseRule) error {
+	if r.Severity == 0 {
+		return fmt.Errorf("logdeb: invalid main severity %d", r.Severity)
+	}
+	if r.DebugLevel == 0 {
+		return fmt.Errorf("logdeb: invalid main debug level %d", r.DebugLevel)
+	}
+	return checkRule(r.Severity, r.DebugLevel)
+}
+
+// Rules returns the current rules with the effective values
+func (l *SLogger) Rules() SRules {
+	r := l.getRules()
+	rules := SRules{
+		Main:          SRule(r.main),
+		UseFncRules:   r.useFncRules,
+		Writers:       make(map[string]SWriterRules, len(r.writers)),
+		MaxSeverity:   r.maxSeverity,
+		MaxDebugLevel: r.maxDebLev,
+	}
+	for name, wr := range r.writers {
+		er := wr.get(r.main)
+		wrs := SWriterRules{SRule: SRule(er)}
+		if len(wr.FncRules) > 0 {
+			wrs.FncRules = make(map[tFncName]SRule, len(wr.FncRules))
+			for fnc, fr := range wr.FncRules {
+				wrs.FncRules[fnc] = SRule(fr.get(er))
+			}
+		}
+		rules.Writers[name] = wrs
+	}
+	return rules
+}
+
+// SetWriterLevel set the severity and debug level of the writer rule.
+// A zero value means the main rule value
+func (l *SLogger) SetWriterLevel(writer string, sev tSeverity, debLev tDebLevel) error {
+	if err := checkRule(sev, debLev); err != nil {
+		return err
+	}
+	return l.updateRules(func(r *sRules) error {
+		wr, ok := r.writers[writer]
+		if !ok {
+			return fmt.Errorf("logdeb: unknown writer %q", writer)
+		}
+		wr.sBaseRule = sBaseRule{sev, debLev}
+		r.writers[writer] = wr
+		return nil
+	})
+}
+
+// SetFncRule add or change the function rule of the writer.
+// A zero value means the writer rule value
+func (l *SLogger) SetFncRule(writer string, fnc tFncName, sev tSeverity, debLev tDebLevel) error {
+	if err := checkRule(sev, debLev); err != nil {
+		return err
+	}
+	return l.updateRules(func(r *sRules) error {
+		wr, ok := r.writers[writer]
+		if !ok {
+			return fmt.Errorf("logdeb: unknown writer %q", writer)
+		}
+		fncRules := make(map[tFncName]sBaseRule, len(wr.FncRules)+1)
+		for f, fr := range wr.FncRules {
+			fncRules[f] = fr
+		}
+		fncRules[fnc] = sBaseRule{sev, debLev}
+		wr.FncRules = fncRules
+		r.writers[writer] = wr
+		return nil
+	})
+}
+
+// RemoveFncRule remove the function rule of the writer
+func (l *SLogger) RemoveFncRule(writer string, fnc tFncName) error {
+	return l.updateRules(func(r *sRules) error {
+		wr, ok := r.writers[writer]
+		if !ok {
+			return fmt.Errorf("logdeb: unknown writer %q", writer)
+		}
+		if _, ok := wr.FncRules[fnc]; !ok {
+			return fmt.Errorf("logdeb: writer %q has no rule for function %q", writer, fnc)
+		}
+		fncRules := make(map[tFncName]sBaseRule, len(wr.FncRules))
+		for f, fr := range wr.FncRules {
+			if f != fnc {
+				fncRules[f] = fr
+			}
+		}
+		wr.FncRules = fncRules
+		r.writers[writer] = wr
+		return nil
+	})
+}
@@ -0,0 +1,152 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"testing"
+)
+
+func TestRulesWriterLevel(t *testing.T) {
+	name := "TestRulesWriterLevel"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"sev":2}, "memory":{"sev":5, "dlev":3}}`)
+	mw := l.Writer("memory").(*SMemoryWriter)
+	defer l.Destroy()
+	r := l.Rules()
+	if r.MaxSeverity != SEVDEBUG || r.MaxDebugLevel != DLV {
+		t.Errorf("%s: unexpected max values %v", name, r)
+	}
+	// lower the writer rule, the main rule is used for the debug level
+	if err := l.SetWriterLevel("memory", SEVWARN, 0); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	// without debug rules the debug messages are discarded immediately
+	r = l.Rules()
+	if r.Writers["memory"].SRule != (SRule{SEVWARN, DLB}) || r.MaxSeverity != SEVWARN || r.MaxDebugLevel != 0 {
+		t.Errorf("%s: unexpected rules after SetWriterLevel %v", name, r)
+	}
+	l.Info(fnc, "test rules info")
+	l.Warn(fnc, "test rules warning")
+	l.Flush()
+	if entries := mw.Entries(); len(entries) != 1 || entries[0].Sev != SEVWARN {
+		t.Errorf("%s: unexpected entries %v", name, entries)
+	}
+	if err := l.SetWriterLevel("missing", SEVWARN, 0); err == nil {
+		t.Errorf("%s: expected error for unknown writer", name)
+	}
+	if err := l.SetWriterLevel("memory", SEVDEBUG+1, 0); err == nil {
+		t.Errorf("%s: expected error for invalid severity", name)
+	}
+}
+
+func TestRulesFncRule(t *testing.T) {
+	name := "TestRulesFncRule"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"usefncrules":true}, "memory":{"sev":2}}`)
+	mw := l.Writer("memory").(*SMemoryWriter)
+	defer l.Destroy()
+	if err := l.SetFncRule("memory", fnc+".writeme", SEVDEBUG, 0); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	r := l.Rules()
+	if fr := r.Writers["memory"].FncRules[fnc+".writeme"]; fr != (SRule{SEVDEBUG, DLB}) {
+		t.Errorf("%s: unexpected function rule %v", name, fr)
+	}
+	l.Deb(fnc+".writeme", "test rules fnc rule")
+	l.Deb(fnc+".dontwriteme", "test rules without fnc rule")
+	l.Flush()
+	if entries := mw.Entries(); len(entries) != 1 || entries[0].Msg != "test rules fnc rule" {
+		t.Errorf("%s: unexpected entries %v", name, entries)
+	}
+	if err := l.RemoveFncRule("memory", fnc+".writeme"); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if _, ok := l.Rules().Writers["memory"].FncRules[fnc+".writeme"]; ok {
+		t.Errorf("%s: function rule not removed", name)
+	}
+	if err := l.RemoveFncRule("memory", fnc+".writeme"); err == nil {
+		t.Errorf("%s: expected error removing a missing function rule", name)
+	}
+	// the rules returned before the change are not modified
+	if _, ok := r.Writers["memory"].FncRules[fnc+".writeme"]; !ok {
+		t.Errorf("%s: previous rules modified", name)
+	}
+}
+
+func TestRulesSeverityDecrease(t *testing.T) {
+	name := "TestRulesSeverityDecrease"
+	l := NewLogDeb(10, `{"main":{"sev":5}, "memory":{}}`)
+	defer l.Destroy()
+	l.SetSeverity(SEVWARN)
+	l.SetDebugLevel(DLB)
+	if r := l.Rules(); r.MaxSeverity != SEVWARN || r.Writers["memory"].Severity != SEVWARN {
+		t.Errorf("%s: max severity not recomputed %v", name, r)
+	}
+}
+
+func TestRulesFncRuleMax(t *testing.T) {
+	name := "TestRulesFncRuleMax"
+	fnc := tFncName(name)
+	l := NewLogDeb(10, `{"main":{"usefncrules":true}, "memory":{"sev":2, "fncrules":{"TestRulesFncRuleMax.writeme":{"sev":5, "dlev":3}}}}`)
+	mw := l.Writer("memory").(*SMemoryWriter)
+	defer l.Destroy()
+	// the function rules are included in the max values
+	if r := l.Rules(); r.MaxSeverity != SEVDEBUG || r.MaxDebugLevel != DLV {
+		t.Errorf("%s: unexpected max values %v", name, r)
+	}
+	l.Debl(fnc+".writeme", "test rules fnc rule max", DLV)
+	l.Flush()
+	if entries := mw.Entries(); len(entries) != 1 || entries[0].DebLev != DLV {
+		t.Errorf("%s: unexpected entries %v", name, entries)
+	}
+	// without the function rule the fast path discards the debug messages
+	if err := l.RemoveFncRule("memory", fnc+".writeme"); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if r := l.Rules(); r.MaxSeverity != SEVERROR || r.MaxDebugLevel != 0 {
+		t.Errorf("%s: max values not recomputed %v", name, r)
+	}
+	// the function rules are not used
+	if err := l.SetFncRule("memory", fnc+".writeme", SEVDEBUG, 0); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	l.SetUseFncRules(false)
+	if r := l.Rules(); r.MaxSeverity != SEVERROR {
+		t.Errorf("%s: unused function rule in max values %v", name, r)
+	}
+}
+
+func TestRulesMainInvalid(t *testing.T) {
+	name := "TestRulesMainInvalid"
+	l := NewLogDeb(10, `{"main":{"sev":4, "dlev":2}, "memory":{}}`)
+	defer l.Destroy()
+	for _, sev := range []tSeverity{0, -1, SEVDEBUG + 1} {
+		if err := l.SetSeverity(sev); err == nil {
+			t.Errorf("%s: severity %d accepted", name, sev)
+		}
+	}
+	for _, debLev := range []tDebLevel{0, -1} {
+		if err := l.SetDebugLevel(debLev); err == nil {
+			t.Errorf("%s: debug level %d accepted", name, debLev)
+		}
+	}
+	// the invalid values don't change the rules
+	if r := l.Rules(); r.Main != (SRule{SEVINFO, DLE}) || r.Writers["memory"].SRule != (SRule{SEVINFO, DLE}) {
+		t.Errorf("%s: rules changed by invalid values %v", name, r)
+	}
+	if err := l.SetSeverity(SEVWARN); err != nil || l.Severity() != SEVWARN {
+		t.Errorf("%s: valid severity not set. ERR: %v", name, err)
+	}
+}
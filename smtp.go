@@ -0,0 +1,233 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SMTPSEP = "|||"
+
+// SMTP writer defaults
+const (
+	SMTPDEFWINDOW  = 60000 // milliseconds
+	SMTPDEFCONTEXT = 10    // context lines
+	SMTPDEFSUBJECT = "logdeb alert"
+)
+
+// SSmtpWriter emails the messages matching the write rules, typically
+// errors and fatals. Messages written within the time window are sent in
+// one email, together with the last lower severity lines as context.
+// Context lines are only the messages not discarded by the main severity
+// and by the other writers, see logw.
+type SSmtpWriter struct {
+	sync.Mutex
+	l          *log.Logger
+	buf        bytes.Buffer // formatted message
+	mainLogger *SLogger
+	flags      int
+	addr       string // host:port
+	username   string
+	password   string
+	from       string
+	to         []string
+	subject    string
+	window     time.Duration
+	context    int       // number of context lines
+	ctxLines   []string  // last lower severity lines
+	body       []string  // lines of the email
+	alerts     []SLogMsg // alerts in body
+	timer      *time.Timer
+}
+
+// NewSmtpWriter: create SSmtpWriter returning as ILogWriter.
+func NewSmtpWriter() ILogWriter {
+	mw := new(SSmtpWriter)
+	mw.flags = log.Ldate | log.Ltime
+	mw.l = log.New(&mw.buf, "", mw.flags)
+	mw.subject = SMTPDEFSUBJECT
+	mw.window = SMTPDEFWINDOW * time.Millisecond
+	mw.context = SMTPDEFCONTEXT
+	return mw
+}
+
+// getConfig: extract configuration
+func (mw *SSmtpWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["flags"]; t {
+			mw.flags = int(v.(float64))
+			mw.l.SetFlags(mw.flags)
+		}
+		if v, t := confout["addr"]; t {
+			mw.addr = v.(string)
+		}
+		if v, t := confout["username"]; t {
+			mw.username = v.(string)
+		}
+		if v, t := confout["password"]; t {
+			mw.password = v.(string)
+		}
+		if v, t := confout["from"]; t {
+			mw.from = v.(string)
+		}
+		if v, t := confout["to"]; t {
+			for _, to := range v.([]interface{}) {
+				mw.to = append(mw.to, to.(string))
+			}
+		}
+		if v, t := confout["subject"]; t {
+			mw.subject = v.(string)
+		}
+		if v, t := confout["window"]; t {
+			mw.window = time.Duration(v.(float64)) * time.Millisecond
+		}
+		if v, t := confout["context"]; t {
+			mw.context = int(v.(float64))
+		}
+	}
+	return nil
+}
+
+// Init smtp logger.
+func (mw *SSmtpWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	mw.mainLogger = logger
+	if err := mw.getConfig(config); err != nil {
+		return err
+	}
+	if len(mw.addr) == 0 || len(mw.from) == 0 || len(mw.to) == 0 {
+		return errors.New("addr, from or to not configured")
+	}
+	return nil
+}
+
+// format: format the message as text line
+func (mw *SSmtpWriter) format(msg SLogMsg) (string, error) {
+	mw.buf.Reset()
+	if err := writeMsg(mw.l, mw.flags, SMTPSEP, msg); err != nil {
+		return "", err
+	}
+	return mw.buf.String(), nil
+}
+
+// Write message. Messages matching the write rules are sent by email,
+// the others are kept as context.
+func (mw *SSmtpWriter) Write(msg SLogMsg) error {
+	prDeb("smtp.go - Write", "MSG: ", msg)
+	alert := mw.mainLogger.MustWrite("smtp", msg)
+	if !alert && mw.context == 0 {
+		return nil
+	}
+	mw.Lock()
+	defer mw.Unlock()
+	line, err := mw.format(msg)
+	if err != nil {
+		return err
+	}
+	if !alert {
+		mw.ctxLines = append(mw.ctxLines, line)
+		if len(mw.ctxLines) > mw.context {
+			mw.ctxLines = mw.ctxLines[len(mw.ctxLines)-mw.context:]
+		}
+		return nil
+	}
+	if len(mw.ctxLines) > 0 {
+		mw.body = append(mw.body, "--- context ---\n")
+		mw.body = append(mw.body, mw.ctxLines...)
+		mw.body = append(mw.body, "---\n")
+		mw.ctxLines = nil
+	}
+	mw.body = append(mw.body, line)
+	mw.alerts = append(mw.alerts, msg)
+	if mw.window <= 0 {
+		mw.send()
+		return nil
+	}
+	if mw.timer == nil {
+		mw.timer = time.AfterFunc(mw.window, func() {
+			mw.Lock()
+			defer mw.Unlock()
+			mw.timer = nil
+			mw.send()
+		})
+	}
+	return nil
+}
+
+// send: email the alerts. On error the alerts are counted as dropped and
+// given to the fallback writer
+func (mw *SSmtpWriter) send() {
+	if len(mw.alerts) == 0 {
+		return
+	}
+	alerts, body := mw.alerts, mw.body
+	mw.alerts, mw.body = nil, nil
+	if err := mw.sendMail(len(alerts), body); err != nil {
+		mw.mainLogger.writeFailed("smtp", err, alerts...)
+	}
+}
+
+// sendMail: email the body lines
+func (mw *SSmtpWriter) sendMail(alerts int, body []string) error {
+	host, _, err := net.SplitHostPort(mw.addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if len(mw.username) > 0 {
+		auth = smtp.PlainAuth("", mw.username, mw.password, host)
+	}
+	hostname, _ := os.Hostname()
+	var email bytes.Buffer
+	fmt.Fprintf(&email, "From: %s\r\n", mw.from)
+	fmt.Fprintf(&email, "To: %s\r\n", strings.Join(mw.to, ", "))
+	fmt.Fprintf(&email, "Subject: %s - %d messages from %s\r\n", mw.subject, alerts, hostname)
+	fmt.Fprintf(&email, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	email.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&email, "Session: %s\r\n\r\n", mw.mainLogger.SessionId())
+	for _, line := range body {
+		email.WriteString(strings.Replace(line, "\n", "\r\n", -1))
+	}
+	return smtp.SendMail(mw.addr, auth, mw.from, mw.to, email.Bytes())
+}
+
+// Destroy send the pending alerts.
+func (mw *SSmtpWriter) Destroy() {
+	mw.Flush()
+}
+
+// Flush send the pending alerts.
+func (mw *SSmtpWriter) Flush() {
+	mw.Lock()
+	defer mw.Unlock()
+	if mw.timer != nil {
+		mw.timer.Stop()
+		mw.timer = nil
+	}
+	mw.send()
+}
+
+func init() {
+	CreateWriter("smtp", NewSmtpWriter)
+}
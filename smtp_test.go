@@ -0,0 +1,145 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in SMTP server, sends the received emails to a channel
+func startSmtpServer(t *testing.T) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen. ERR: %s", err)
+	}
+	emails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.ToUpper(strings.TrimSpace(line))
+					switch {
+					case strings.HasPrefix(cmd, "DATA"):
+						fmt.Fprint(conn, "354 go ahead\r\n")
+						var data []string
+						for {
+							dl, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if dl == ".\r\n" {
+								break
+							}
+							data = append(data, dl)
+						}
+						emails <- strings.Join(data, "")
+						fmt.Fprint(conn, "250 ok\r\n")
+					case strings.HasPrefix(cmd, "QUIT"):
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "250 ok\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return ln, emails
+}
+
+func TestSmtpAlert(t *testing.T) {
+	name := "TestSmtpAlert"
+	fnc := tFncName(name)
+	ln, emails := startSmtpServer(t)
+	defer ln.Close()
+	config := fmt.Sprintf(`{"main":{"sev":4}, "smtp":{"flags":0, "sev":2, "addr":%q, "from":"app@localhost", "to":["ops@localhost"], "subject":"alert", "window":50, "context":2}}`, ln.Addr().String())
+	l := NewLogDeb(10, config)
+	l.Info(fnc, "smtp context 1")
+	l.Info(fnc, "smtp context 2")
+	l.Info(fnc, "smtp context 3")
+	l.Err(fnc, "smtp error")
+	l.Fatal(fnc, "smtp fatal")
+	var email string
+	select {
+	case email = <-emails:
+	case <-time.After(time.Second):
+		t.Fatalf("%s: email not received", name)
+	}
+	l.Destroy()
+	for _, expect := range []string{"Subject: alert - 2 messages", "TestSmtpAlert[I] ||| smtp context 2", "TestSmtpAlert[I] ||| smtp context 3", "TestSmtpAlert[E] ||| smtp error", "TestSmtpAlert[F] ||| smtp fatal"} {
+		if !strings.Contains(email, expect) {
+			t.Errorf("%s\n EXPECT => %v\n GOT => %v", name, expect, email)
+		}
+	}
+	// the context keeps only the last 2 lines
+	if strings.Contains(email, "smtp context 1") {
+		t.Errorf("%s: unexpected context line in %v", name, email)
+	}
+	select {
+	case email = <-emails:
+		t.Errorf("%s: unexpected email %v", name, email)
+	default:
+	}
+}
+
+func TestSmtpFailure(t *testing.T) {
+	name := "TestSmtpFailure"
+	fnc := tFncName(name)
+	// get a free address, no server is listening
+	ln, _ := startSmtpServer(t)
+	addr := ln.Addr().String()
+	ln.Close()
+	config := fmt.Sprintf(`{"smtp":{"flags":0, "sev":2, "addr":%q, "from":"app@localhost", "to":["ops@localhost"], "window":50}}`, addr)
+	var lock sync.Mutex
+	var errs []string
+	l := NewLogDeb(10, config)
+	l.SetErrorHandler(func(writer string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		errs = append(errs, writer)
+	})
+	// the alerts are sent by the window timer
+	l.Err(fnc, "smtp failure 1")
+	l.Err(fnc, "smtp failure 2")
+	for i := 0; i < 100 && l.DroppedMessages()["smtp"] < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.Destroy()
+	if d := l.DroppedMessages()["smtp"]; d != 2 {
+		t.Errorf("%s: expected 2 dropped messages, got %d", name, d)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(errs) != 1 || errs[0] != "smtp" {
+		t.Errorf("%s: unexpected errors %q", name, errs)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQL writer defaults
+const (
+	SQLDEFTABLE     = "logdeb"
+	SQLDEFBATCHSIZE = 100
+	SQLDEFBATCHAGE  = 1000 // milliseconds
+)
+
+// SSqlWriter inserts messages in a database table through database/sql.
+// The database driver must be imported by the application.
+type SSqlWriter struct {
+	sync.Mutex
+	mainLogger  *SLogger
+	db          *sql.DB
+	driver      string
+	dsn         string
+	table       string
+	createTable bool
+	placeholder string // ? or $ for numbered placeholders like $1
+	fields      string // additional fields as JSON, stored with every message
+	batchSize   int
+	batchAge    time.Duration
+	batch       []SLogMsg
+	batchTime   time.Time // time of the first message in batch
+	done        chan bool // stop the batch age timer
+	wg          sync.WaitGroup
+}
+
+// NewSqlWriter: create SSqlWriter returning as ILogWriter.
+func NewSqlWriter() ILogWriter {
+	qw := new(SSqlWriter)
+	qw.table = SQLDEFTABLE
+	qw.placeholder = "?"
+	qw.fields = "{}"
+	qw.batchSize = SQLDEFBATCHSIZE
+	qw.batchAge = SQLDEFBATCHAGE * time.Millisecond
+	return qw
+}
+
+// getConfig: extract configuration
+func (qw *SSqlWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["driver"]; t {
+			qw.driver = v.(string)
+		}
+		if v, t := confout["dsn"]; t {
+			qw.dsn = v.(string)
+		}
+		if v, t := confout["table"]; t {
+			qw.table = v.(string)
+		}
+		if v, t := confout["createtable"]; t {
+			qw.createTable = v.(bool)
+		}
+		if v, t := confout["placeholder"]; t {
+			qw.placeholder = v.(string)
+		}
+		if v, t := confout["fields"]; t {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			qw.fields = string(b)
+		}
+		if v, t := confout["batchsize"]; t {
+			qw.batchSize = int(v.(float64))
+		}
+		if v, t := confout["batchage"]; t {
+			qw.batchAge = time.Duration(v.(float64)) * time.Millisecond
+		}
+	}
+	if qw.placeholder != "?" && qw.placeholder != "$" {
+		return fmt.Errorf("unknown placeholder %q", qw.placeholder)
+	}
+	if qw.batchSize < 1 {
+		qw.batchSize = 1
+	}
+	return nil
+}
+
+// Init sql logger, open the database and create the table when configured.
+func (qw *SSqlWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	qw.mainLogger = logger
+	if err := qw.getConfig(config); err != nil {
+		return err
+	}
+	if len(qw.driver) == 0 || len(qw.dsn) == 0 {
+		return errors.New("driver or dsn not configured")
+	}
+	var err error
+	if qw.db, err = sql.Open(qw.driver, qw.dsn); err != nil {
+		return err
+	}
+	if qw.createTable {
+		if _, err = qw.db.Exec(qw.createStmt()); err != nil {
+			return err
+		}
+	}
+	if qw.batchAge > 0 {
+		qw.done = make(chan bool)
+		qw.wg.Add(1)
+		go qw.timer()
+	}
+	return nil
+}
+
+// createStmt: statement creating the log table
+func (qw *SSqlWriter) createStmt() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (ts TIMESTAMP, sev INTEGER, dlev INTEGER, fnc VARCHAR(255), session_id VARCHAR(255), msg TEXT, fields TEXT)", qw.table)
+}
+
+// insertStmt: statement inserting a message
+func (qw *SSqlWriter) insertStmt() string {
+	ph := []string{"?", "?", "?", "?", "?", "?", "?"}
+	if qw.placeholder == "$" {
+		for i := range ph {
+			ph[i] = fmt.Sprintf("$%d", i+1)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (ts, sev, dlev, fnc, session_id, msg, fields) VALUES (%s)", qw.table, strings.Join(ph, ", "))
+}
+
+// timer: insert the batch when it is older than batchAge
+func (qw *SSqlWriter) timer() {
+	defer qw.wg.Done()
+	ticker := time.NewTicker(qw.batchAge / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-qw.done:
+			return
+		case <-ticker.C:
+			qw.Lock()
+			if len(qw.batch) > 0 && time.Since(qw.batchTime) >= qw.batchAge {
+				qw.send()
+			}
+			qw.Unlock()
+		}
+	}
+}
+
+// send: insert the batch. On error the batch is counted as dropped and
+// given to the fallback writer
+func (qw *SSqlWriter) send() {
+	if len(qw.batch) == 0 {
+		return
+	}
+	batch := qw.batch
+	qw.batch = nil
+	if err := qw.insert(batch); err != nil {
+		qw.mainLogger.writeFailed("sql", err, batch...)
+	}
+}
+
+// insert: insert the batch in one transaction
+func (qw *SSqlWriter) insert(batch []SLogMsg) error {
+	if qw.db == nil {
+		return errors.New("database not opened")
+	}
+	tx, err := qw.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(qw.insertStmt())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, msg := range batch {
+		if _, err = stmt.Exec(msg.ts, int(msg.sev), int(msg.debLev), string(msg.fnc), msg.sessionId, msg.msg, qw.fields); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Write message in the batch, the batch is inserted when full.
+func (qw *SSqlWriter) Write(msg SLogMsg) error {
+	prDeb("sql.go - Write", "MSG: ", msg)
+	if !qw.mainLogger.MustWrite("sql", msg) {
+		return nil
+	}
+	qw.Lock()
+	defer qw.Unlock()
+	if len(qw.batch) == 0 {
+		qw.batchTime = time.Now()
+	}
+	qw.batch = append(qw.batch, msg)
+	if len(qw.batch) >= qw.batchSize {
+		qw.send()
+	}
+	return nil
+}
+
+// Destroy stop the timer, insert the batch and close the database.
+func (qw *SSqlWriter) Destroy() {
+	if qw.done != nil {
+		close(qw.done)
+		qw.wg.Wait()
+		qw.done = nil
+	}
+	qw.Flush()
+	if qw.db != nil {
+		qw.db.Close()
+	}
+}
+
+// Flush insert the batch.
+func (qw *SSqlWriter) Flush() {
+	qw.Lock()
+	defer qw.Unlock()
+	qw.send()
+}
+
+func init() {
+	CreateWriter("sql", NewSqlWriter)
+}
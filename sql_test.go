@@ -0,0 +1,139 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sTestDB records the statements executed through the stand-in sql driver
+type sTestDB struct {
+	sync.Mutex
+	execs   []string
+	rows    [][]driver.Value
+	commits int
+	fail    bool // the inserts fail
+}
+
+var testDBs = map[string]*sTestDB{}
+
+type sTestDriver struct{}
+type sTestConn struct{ db *sTestDB }
+type sTestStmt struct {
+	db    *sTestDB
+	query string
+}
+
+func (sTestDriver) Open(dsn string) (driver.Conn, error) {
+	return &sTestConn{testDBs[dsn]}, nil
+}
+
+func (c *sTestConn) Prepare(query string) (driver.Stmt, error) {
+	return &sTestStmt{c.db, query}, nil
+}
+
+func (c *sTestConn) Close() error { return nil }
+
+func (c *sTestConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *sTestConn) Commit() error {
+	c.db.Lock()
+	defer c.db.Unlock()
+	c.db.commits++
+	return nil
+}
+
+func (c *sTestConn) Rollback() error { return nil }
+
+func (s *sTestStmt) Close() error { return nil }
+
+func (s *sTestStmt) NumInput() int { return -1 }
+
+func (s *sTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+	s.db.execs = append(s.db.execs, s.query)
+	if strings.HasPrefix(s.query, "INSERT") && s.db.fail {
+		return nil, errors.New("insert failed")
+	}
+	if strings.HasPrefix(s.query, "INSERT") {
+		s.db.rows = append(s.db.rows, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *sTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func init() {
+	sql.Register("logdebtest", sTestDriver{})
+}
+
+func TestSqlBatch(t *testing.T) {
+	name := "TestSqlBatch"
+	db := new(sTestDB)
+	testDBs[name] = db
+	config := `{"sql":{"sev":4, "driver":"logdebtest", "dsn":"TestSqlBatch", "table":"applog", "createtable":true, "placeholder":"$", "batchsize":2, "batchage":0, "fields":{"app":"test"}}}`
+	l := NewLogDeb(10, config)
+	l.SetSessionId(name)
+	l.Err(tFncName(name), "sql error")
+	// Don't write this because the configured Severity is set to 4
+	l.Deb(tFncName(name), "sql debug")
+	l.Info(tFncName(name), "sql info")
+	l.Warn(tFncName(name), "sql warning")
+	l.Destroy()
+	if len(db.execs) != 4 || !strings.HasPrefix(db.execs[0], "CREATE TABLE IF NOT EXISTS applog ") {
+		t.Fatalf("%s: unexpected statements %q", name, db.execs)
+	}
+	if db.execs[1] != "INSERT INTO applog (ts, sev, dlev, fnc, session_id, msg, fields) VALUES ($1, $2, $3, $4, $5, $6, $7)" {
+		t.Errorf("%s: unexpected insert %q", name, db.execs[1])
+	}
+	if db.commits != 2 {
+		t.Errorf("%s: expected 2 transactions, got %d", name, db.commits)
+	}
+	row := db.rows[1]
+	if row[1] != int64(SEVINFO) || row[3] != name || row[4] != name || row[5] != "sql info" || row[6] != `{"app":"test"}` {
+		t.Errorf("%s: unexpected row %v", name, row)
+	}
+}
+
+func TestSqlFailure(t *testing.T) {
+	name := "TestSqlFailure"
+	fnc := tFncName(name)
+	testDBs[name] = &sTestDB{fail: true}
+	config := `{"sql":{"sev":4, "driver":"logdebtest", "dsn":"TestSqlFailure", "table":"applog", "batchsize":2, "batchage":0}}`
+	var errs []string
+	l := NewLogDeb(10, config)
+	l.SetErrorHandler(func(writer string, err error) {
+		errs = append(errs, writer+": "+err.Error())
+	})
+	// the first batch is inserted by Write, the second by Destroy
+	l.Err(fnc, "sql failure 1")
+	l.Err(fnc, "sql failure 2")
+	l.Err(fnc, "sql failure 3")
+	l.Destroy()
+	if d := l.DroppedMessages()["sql"]; d != 3 {
+		t.Errorf("%s: expected 3 dropped messages, got %d", name, d)
+	}
+	if len(errs) != 2 || errs[0] != "sql: insert failed" {
+		t.Errorf("%s: unexpected errors %q", name, errs)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"io"
+	"log"
+	"sync"
+)
+
+const STREAMSEP = "|||"
+
+// an io.Writer with locker.
+type SMuxStream struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// SStreamWriter writes messages to any io.Writer
+type SStreamWriter struct {
+	l          *log.Logger
+	mainLogger *SLogger
+	name       string
+	flags      int
+	ms         *SMuxStream
+}
+
+// write to io.Writer.
+func (ms *SMuxStream) Write(b []byte) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	return ms.w.Write(b)
+}
+
+// NewStreamWriter: create SStreamWriter writing to w, returning as ILogWriter.
+// The writer must be registered with CreateStreamWriter to be configured by name.
+func NewStreamWriter(w io.Writer) ILogWriter {
+	sw := new(SStreamWriter)
+	sw.ms = &SMuxStream{w: w}
+	sw.flags = log.Ldate | log.Ltime
+	sw.l = log.New(sw.ms, "", sw.flags)
+	return sw
+}
+
+// CreateStreamWriter register a stream writer adapter writing to w.
+// The name is the key used in the configuration, like {"name":{"sev":5}}
+func CreateStreamWriter(name string, w io.Writer) {
+	CreateWriter(name, func() ILogWriter {
+		sw := NewStreamWriter(w).(*SStreamWriter)
+		sw.name = name
+		return sw
+	})
+}
+
+// getConfig: extract configuration
+func (sw *SStreamWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["flags"]; t {
+			sw.flags = int(v.(float64))
+			sw.l.SetFlags(sw.flags)
+		}
+	}
+	return nil
+}
+
+// Init stream logger.
+func (sw *SStreamWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	sw.mainLogger = logger
+	if err := sw.getConfig(config); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write message on the stream.
+func (sw *SStreamWriter) Write(msg SLogMsg) error {
+	prDeb("stream.go - Write", "MSG: ", msg)
+	if !sw.mainLogger.MustWrite(sw.name, msg) {
+		return nil
+	}
+	return writeMsg(sw.l, sw.flags, STREAMSEP, msg)
+}
+
+// implementing method. empty.
+func (sw *SStreamWriter) Destroy() {
+
+}
+
+// implementing method. empty.
+func (sw *SStreamWriter) Flush() {
+
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Register the stream writer, replacing the one registered by a previous run
+func createTestStream(name string, w io.Writer) {
+	delete(logWriters, name)
+	CreateStreamWriter(name, w)
+}
+
+// Run stream writer test registering the writer with the test name
+func runTestStream(t *testing.T, name string, config string, tmsgs []STLogMsg) {
+	var buf bytes.Buffer
+	createTestStream(name, &buf)
+	executeTest(config, tmsgs)
+	out := buf.String()
+	prTest("STREAM OUTPUT:", out)
+	checkResult(t, out, name, STREAMSEP, tmsgs)
+}
+
+func TestStreamDeb1(t *testing.T) {
+	name := "TestStreamDeb1"
+	fnc := tFncName(name)
+	config := `{"TestStreamDeb1":{"flags":0, "sev":5, "dlev":2}}`
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test stream"}, true},
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test stream with debug level 2", debLev: 2}, true},
+		// Don't write this because the configured DebugLevel is set to 2
+		STLogMsg{SLogMsg{fnc: fnc, msg: "test stream with debug level 3", debLev: 3}, false},
+	}
+	runTestStream(t, name, config, tmsgs[:])
+}
+
+func TestStreamDeb2(t *testing.T) {
+	name := "TestStreamDeb2"
+	config := `{"main":{"usefncrules":true},"TestStreamDeb2":{"flags":0, "sev":2, "fncrules":{"TestStreamDeb2.writeme":{"sev":5}}}}`
+	tmsgs := []STLogMsg{
+		STLogMsg{SLogMsg{fnc: "TestStreamDeb2.writeme", msg: "test stream with rule"}, true},
+		// Don't write this because there is no rule for the function
+		STLogMsg{SLogMsg{fnc: "TestStreamDeb2.dontwriteme", msg: "test stream without rule"}, false},
+	}
+	runTestStream(t, name, config, tmsgs[:])
+}
+
+// sBlockingWriter blocks the writes until released
+type sBlockingWriter struct {
+	release chan bool
+	buf     bytes.Buffer
+}
+
+func (bw *sBlockingWriter) Write(b []byte) (int, error) {
+	<-bw.release
+	return bw.buf.Write(b)
+}
+
+func TestStreamSlowWriter(t *testing.T) {
+	name := "TestStreamSlowWriter"
+	fnc := tFncName(name)
+	bw := &sBlockingWriter{release: make(chan bool)}
+	createTestStream(name, bw)
+	config := `{"TestStreamSlowWriter":{"flags":0, "sev":5, "queuesize":1, "overflow":"dropnew"}, "memory":{"sev":5}}`
+	l := NewLogDeb(10, config)
+	for i := 0; i < 5; i++ {
+		l.Deb(fnc, "test slow writer")
+	}
+	// the memory writer is not delayed by the blocked stream writer
+	mw := l.Writer("memory").(*SMemoryWriter)
+	for i := 0; i < 100 && len(mw.Entries()) < 5; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(mw.Entries()); n != 5 {
+		t.Errorf("%s: memory writer delayed, %d messages written", name, n)
+	}
+	close(bw.release)
+	l.Destroy()
+	// at most one message is blocked in Write and one in the queue, the others are dropped
+	d := l.DroppedMessages()
+	n := strings.Count(bw.buf.String(), "test slow writer")
+	if d[name] < 3 || d[name]+uint64(n) != 5 || d["memory"] != 0 {
+		t.Errorf("%s: unexpected dropped messages %v, %d messages written", name, d, n)
+	}
+	// the writer queue drops are counted by severity
+	ds := l.WriterDroppedBySeverity()
+	if ds[name][SEVDEBUG] != d[name] || ds["memory"][SEVDEBUG] != 0 {
+		t.Errorf("%s: unexpected dropped messages by severity %v", name, ds)
+	}
+}
+
+func TestStreamDropOld(t *testing.T) {
+	name := "TestStreamDropOld"
+	fnc := tFncName(name)
+	bw := &sBlockingWriter{release: make(chan bool)}
+	createTestStream(name, bw)
+	l := NewLogDeb(10, `{"TestStreamDropOld":{"flags":0, "sev":5, "queuesize":1, "overflow":"dropold"}}`)
+	for i := 0; i < 10; i++ {
+		l.Err(fnc, "test drop old")
+	}
+	// the flush times out, but the messages queued before it are dispatched to the blocked writer
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.FlushContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("%s: expected context.DeadlineExceeded, got %v", name, err)
+	}
+	close(bw.release)
+	l.Destroy()
+	// the evicted messages are counted as dropped by the writer
+	d := l.DroppedMessages()[name]
+	n := strings.Count(bw.buf.String(), "test drop old")
+	if d < 8 || d+uint64(n) != 10 || l.WriterDroppedBySeverity()[name][SEVERROR] != d {
+		t.Errorf("%s: unexpected dropped messages %d, %d messages written", name, d, n)
+	}
+}
+
+func TestStreamDestroyContext(t *testing.T) {
+	name := "TestStreamDestroyContext"
+	fnc := tFncName(name)
+	bw := &sBlockingWriter{release: make(chan bool)}
+	createTestStream(name, bw)
+	l := NewLogDeb(10, `{"TestStreamDestroyContext":{"flags":0, "sev":5}}`)
+	l.Deb(fnc, "test destroy context")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// the blocked writer can't write the pending message
+	if err := l.DestroyContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("%s: expected context.DeadlineExceeded, got %v", name, err)
+	}
+	close(bw.release)
+	if err := l.DestroyContext(context.Background()); err != nil {
+		t.Errorf("%s: unexpected error %v", name, err)
+	}
+	if !strings.Contains(bw.buf.String(), "test destroy context") {
+		t.Errorf("%s: pending message not written %q", name, bw.buf.String())
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SYSLOGDEFADDR = "/dev/log"
+
+// Syslog formats
+const (
+	SYSLOGRFC5424 = "rfc5424"
+	SYSLOGRFC3164 = "rfc3164"
+)
+
+// Syslog facility names, see RFC 5424 section 6.2.1
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SSyslogWriter sends messages to a syslog daemon
+type SSyslogWriter struct {
+	sync.Mutex
+	mainLogger *SLogger
+	network    string // unixgram, unix, udp or tcp. Empty means local socket
+	addr       string // socket path or host:port
+	facility   int
+	appName    string
+	hostname   string
+	format     string // rfc5424 or rfc3164
+	conn       net.Conn
+}
+
+// NewSyslogWriter: create SSyslogWriter returning as ILogWriter.
+func NewSyslogWriter() ILogWriter {
+	sw := new(SSyslogWriter)
+	sw.addr = SYSLOGDEFADDR
+	sw.facility = syslogFacilities["user"]
+	sw.appName = filepath.Base(os.Args[0])
+	sw.hostname, _ = os.Hostname()
+	sw.format = SYSLOGRFC5424
+	return sw
+}
+
+// syslogSeverity: map tSeverity to syslog severity
+func syslogSeverity(sev tSeverity) int {
+	switch sev {
+	case SEVFATAL:
+		return 2 // critical
+	case SEVERROR:
+		return 3 // error
+	case SEVWARN:
+		return 4 // warning
+	case SEVINFO:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
+
+// getConfig: extract configuration
+func (sw *SSyslogWriter) getConfig(config map[string]interface{}) error {
+	if len(config) > 0 {
+		confout := getConfig(config)
+		if v, t := confout["network"]; t {
+			sw.network = v.(string)
+		}
+		if v, t := confout["addr"]; t {
+			sw.addr = v.(string)
+		}
+		if v, t := confout["facility"]; t {
+			switch f := v.(type) {
+			case float64:
+				sw.facility = int(f)
+			case string:
+				fac, ok := syslogFacilities[strings.ToLower(f)]
+				if !ok {
+					return fmt.Errorf("unknown syslog facility %q", f)
+				}
+				sw.facility = fac
+			}
+		}
+		if v, t := confout["appname"]; t {
+			sw.appName = v.(string)
+		}
+		if v, t := confout["hostname"]; t {
+			sw.hostname = v.(string)
+		}
+		if v, t := confout["format"]; t {
+			sw.format = strings.ToLower(v.(string))
+		}
+	}
+	if sw.format != SYSLOGRFC5424 && sw.format != SYSLOGRFC3164 {
+		return fmt.Errorf("unknown syslog format %q", sw.format)
+	}
+	if sw.facility < 0 || sw.facility > 23 {
+		return fmt.Errorf("syslog facility %d out of range", sw.facility)
+	}
+	return nil
+}
+
+// dial: connect to the syslog daemon
+func (sw *SSyslogWriter) dial() error {
+	prDeb("syslog.go - dial", "network:", sw.network, "addr:", sw.addr)
+	var err error
+	if sw.network != "" {
+		sw.conn, err = net.Dial(sw.network, sw.addr)
+		return err
+	}
+	// local socket, could be datagram or stream
+	for _, network := range []string{"unixgram", "unix"} {
+		if sw.conn, err = net.Dial(network, sw.addr); err == nil {
+			sw.network = network
+			return nil
+		}
+	}
+	return err
+}
+
+// Init syslog logger.
+func (sw *SSyslogWriter) Init(logger *SLogger, config map[string]interface{}) error {
+	sw.mainLogger = logger
+	if err := sw.getConfig(config); err != nil {
+		return err
+	}
+	return nil
+}
+
+// formatMsg: build the syslog message, with the time of the log call
+func (sw *SSyslogWriter) formatMsg(msg SLogMsg) string {
+	pri := sw.facility*8 + syslogSeverity(msg.sev)
+	if sw.format == SYSLOGRFC3164 {
+		return fmt.Sprintf("<%d>%s %s %s[%d]: %v[%s] %s", pri, msg.ts.Format(time.Stamp), sw.hostname, sw.appName, os.Getpid(), msg.fnc, msg.sev, msg.msg)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", pri, msg.ts.Format(time.RFC3339Nano), syslogField(sw.hostname, 255), syslogField(sw.appName, 48), os.Getpid(), syslogField(string(msg.fnc), 32), msg.msg)
+}
+
+// syslogField: make a RFC 5424 header field, printable ascii without spaces
+func syslogField(s string, maxLen int) string {
+	f := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(f) > maxLen {
+		f = f[:maxLen]
+	}
+	if len(f) == 0 {
+		return "-"
+	}
+	return f
+}
+
+// frame: add the framing needed by stream connections
+func (sw *SSyslogWriter) frame(line string) string {
+	switch {
+	case sw.network == "tcp" && sw.format == SYSLOGRFC5424:
+		// octet counting framing, see RFC 6587
+		return fmt.Sprintf("%d %s", len(line), line)
+	case sw.network == "tcp" || sw.network == "unix":
+		return line + "\n"
+	}
+	return line
+}
+
+// Write message to syslog.
+func (sw *SSyslogWriter) Write(msg SLogMsg) error {
+	prDeb("syslog.go - Write", "MSG: ", msg)
+	if !sw.mainLogger.MustWrite("syslog", msg) {
+		return nil
+	}
+	sw.Lock()
+	defer sw.Unlock()
+	line := sw.formatMsg(msg)
+	var err error
+	// retry once reconnecting when the connection is lost
+	for i := 0; i < 2; i++ {
+		if sw.conn == nil {
+			if err = sw.dial(); err != nil {
+				return err
+			}
+		}
+		if _, err = sw.conn.Write([]byte(sw.frame(line))); err == nil {
+			return nil
+		}
+		sw.conn.Close()
+		sw.conn = nil
+	}
+	return err
+}
+
+// Destroy close the connection.
+func (sw *SSyslogWriter) Destroy() {
+	sw.Lock()
+	defer sw.Unlock()
+	if sw.conn != nil {
+		sw.conn.Close()
+		sw.conn = nil
+	}
+}
+
+// implementing method. empty.
+func (sw *SSyslogWriter) Flush() {
+
+}
+
+func init() {
+	CreateWriter("syslog", NewSyslogWriter)
+}
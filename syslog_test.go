@@ -0,0 +1,99 @@
+// Copyright 2014 Massimo Fidanza.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logdeb
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Read the datagrams received by the stand-in syslog daemon
+func readSyslog(t *testing.T, conn net.PacketConn, count int) []string {
+	var out []string
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < count; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("syslog read error. ERR: %s", err)
+		}
+		out = append(out, string(buf[:n]))
+	}
+	return out
+}
+
+func TestSyslogUnix(t *testing.T) {
+	name := "TestSyslogUnix"
+	fnc := tFncName(name + ".fnc")
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Skipf("%s: unix datagram socket not available. ERR: %s", name, err)
+	}
+	defer conn.Close()
+	config := fmt.Sprintf(`{"syslog":{"sev":3, "addr":%q, "facility":"local0", "appname":"logdeb", "hostname":"host"}}`, sock)
+	l := NewLogDeb(10, config)
+	l.Err(fnc, "syslog error")
+	// Don't write this because the configured Severity is set to 3
+	l.Info(fnc, "syslog info")
+	l.Warn(fnc, "syslog warning")
+	l.Destroy()
+	out := readSyslog(t, conn, 2)
+	expect := []string{
+		fmt.Sprintf(`^<131>1 \S+ host logdeb %d TestSyslogUnix.fnc - syslog error$`, os.Getpid()),
+		fmt.Sprintf(`^<132>1 \S+ host logdeb %d TestSyslogUnix.fnc - syslog warning$`, os.Getpid()),
+	}
+	for i := range expect {
+		if !regexp.MustCompile(expect[i]).MatchString(out[i]) {
+			t.Errorf("%s\n EXPECT => %v\n GOT => %v", name, expect[i], out[i])
+		}
+	}
+}
+
+func TestSyslogUDP3164(t *testing.T) {
+	name := "TestSyslogUDP3164"
+	fnc := tFncName(name + ".fnc")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: can not listen. ERR: %s", name, err)
+	}
+	defer conn.Close()
+	config := fmt.Sprintf(`{"syslog":{"sev":5, "network":"udp", "addr":%q, "format":"rfc3164", "appname":"logdeb", "hostname":"host"}}`, conn.LocalAddr().String())
+	l := NewLogDeb(10, config)
+	l.Deb(fnc, "syslog debug")
+	l.Destroy()
+	out := readSyslog(t, conn, 1)
+	expect := fmt.Sprintf(`^<15>\w{3} [ \d]\d \d\d:\d\d:\d\d host logdeb\[%d\]: TestSyslogUDP3164.fnc\[D\] syslog debug$`, os.Getpid())
+	if !regexp.MustCompile(expect).MatchString(out[0]) {
+		t.Errorf("%s\n EXPECT => %v\n GOT => %v", name, expect, out[0])
+	}
+}
+
+func TestSyslogLogTime(t *testing.T) {
+	name := "TestSyslogLogTime"
+	sw := &SSyslogWriter{format: SYSLOGRFC5424, facility: 1, hostname: "host", appName: "logdeb"}
+	// the message has the time of the log call, not of the write
+	ts := time.Date(2014, 5, 1, 10, 20, 30, 0, time.UTC)
+	line := sw.formatMsg(SLogMsg{fnc: "fnc", msg: "syslog log time", sev: SEVINFO, ts: ts})
+	expect := fmt.Sprintf("<14>1 2014-05-01T10:20:30Z host logdeb %d fnc - syslog log time", os.Getpid())
+	if line != expect {
+		t.Errorf("%s\n EXPECT => %v\n GOT => %v", name, expect, line)
+	}
+}